@@ -0,0 +1,502 @@
+package depechebot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type BroadcastStatus string
+
+const (
+	BroadcastRunning  BroadcastStatus = "running"
+	BroadcastDone     BroadcastStatus = "done"
+	BroadcastCanceled BroadcastStatus = "canceled"
+)
+
+type RecipientStatus string
+
+const (
+	RecipientPending RecipientStatus = "pending"
+	RecipientSent    RecipientStatus = "sent"
+	RecipientFailed  RecipientStatus = "failed"
+	// RecipientBlocked chat blocked the bot or doesn't exist anymore,
+	// it's marked Abandoned.
+	RecipientBlocked RecipientStatus = "blocked"
+)
+
+// Broadcast is a persistent job sending Message to its recipients,
+// see Bot.StartBroadcast.
+type Broadcast struct {
+	ID         int              `json:"id"`
+	Message    BroadcastMessage `json:"message"`
+	Status     BroadcastStatus  `json:"status"`
+	CreateTime time.Time        `json:"create_time"`
+	UpdateTime time.Time        `json:"update_time"`
+}
+
+// Recipient is a chat of Broadcast with its sending status.
+// Chats failing with temporary errors stay pending and are retried
+// up to broadcastAttempts times.
+type Recipient struct {
+	ChatID   ChatID          `json:"chat_id"`
+	Status   RecipientStatus `json:"status"`
+	Error    string          `json:"error"`    // of the last attempt, if failed
+	Time     time.Time       `json:"time"`     // of the last attempt, zero if not attempted
+	Attempts int             `json:"attempts"` // number of attempts
+}
+
+const broadcastAttempts = 3
+
+// broadcastRetryDelay is the delay before retrying a recipient.
+var broadcastRetryDelay = time.Minute
+
+// attempt records attempt to send to r at t, which failed if err is not nil.
+func (r *Recipient) attempt(err error, t time.Time) {
+	r.Time = t
+	r.Attempts++
+	if err == nil {
+		r.Status = RecipientSent
+		r.Error = ""
+		return
+	}
+
+	r.Error = err.Error()
+	apiErr, _ := err.(*APIError)
+	switch {
+	case apiErr != nil && apiErr.Permanent():
+		r.Status = RecipientBlocked
+	case apiErr != nil && apiErr.Temporary() && r.Attempts < broadcastAttempts:
+		r.Status = RecipientPending
+	default:
+		r.Status = RecipientFailed
+	}
+}
+
+// BroadcastMessage is a Chattable which could be saved by Model:
+// tgbotapi.MessageConfig, PhotoConfig, DocumentConfig or AudioConfig.
+// Files should be either FileID or path, not uploaded bytes or reader.
+type BroadcastMessage struct {
+	tgbotapi.Chattable
+}
+
+// broadcastMessageJSON is BroadcastMessage with its type.
+type broadcastMessageJSON struct {
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config"`
+}
+
+func (m BroadcastMessage) MarshalJSON() ([]byte, error) {
+	var typ string
+	var file interface{}
+	switch msg := m.Chattable.(type) {
+	case tgbotapi.MessageConfig:
+		typ = "message"
+	case tgbotapi.PhotoConfig:
+		typ, file = "photo", msg.File
+	case tgbotapi.DocumentConfig:
+		typ, file = "document", msg.File
+	case tgbotapi.AudioConfig:
+		typ, file = "audio", msg.File
+	default:
+		return nil, fmt.Errorf("broadcast message of type %T is not supported", m.Chattable)
+	}
+	if _, ok := file.(string); file != nil && !ok {
+		return nil, fmt.Errorf("broadcast file of type %T is not supported", file)
+	}
+
+	config, err := json.Marshal(m.Chattable)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(broadcastMessageJSON{typ, config})
+}
+
+func (m *BroadcastMessage) UnmarshalJSON(b []byte) error {
+	var msg broadcastMessageJSON
+	err := json.Unmarshal(b, &msg)
+	if err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case "message":
+		config := tgbotapi.MessageConfig{}
+		err = json.Unmarshal(msg.Config, &config)
+		m.Chattable = config
+	case "photo":
+		config := tgbotapi.PhotoConfig{}
+		err = json.Unmarshal(msg.Config, &config)
+		m.Chattable = config
+	case "document":
+		config := tgbotapi.DocumentConfig{}
+		err = json.Unmarshal(msg.Config, &config)
+		m.Chattable = config
+	case "audio":
+		config := tgbotapi.AudioConfig{}
+		err = json.Unmarshal(msg.Config, &config)
+		m.Chattable = config
+	default:
+		return fmt.Errorf("unknown broadcast message type %q", msg.Type)
+	}
+
+	return err
+}
+
+// BroadcastProgress counts Broadcast recipients by status.
+type BroadcastProgress struct {
+	Broadcast
+	Total   int
+	Pending int
+	Sent    int
+	Failed  int
+	Blocked int
+}
+
+// broadcasts is shared by all copies of Bot.
+type broadcasts struct {
+	sync.Mutex
+	canceled map[int]bool
+	wake     chan struct{}
+}
+
+func newBroadcasts() *broadcasts {
+	return &broadcasts{
+		canceled: make(map[int]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+var errNoBroadcastModel = errors.New("broadcasts need Model implementing BroadcastStore")
+
+// broadcastStore returns Config.Model if it saves broadcasts.
+func (b Bot) broadcastStore() (BroadcastStore, error) {
+	store, ok := b.Config.Model.(BroadcastStore)
+	if !ok {
+		return nil, errNoBroadcastModel
+	}
+	return store, nil
+}
+
+// StartBroadcast saves broadcast of msg to chats and starts sending it.
+// Chats are sent to in order, with rate limits. Unlike SendBroadChan,
+// broadcast is resumed by Run after restart, so a chat could get msg twice
+// if bot stopped right after sending to it.
+func (b Bot) StartBroadcast(msg tgbotapi.Chattable, chatIDs []ChatID) (*Broadcast, error) {
+	store, err := b.broadcastStore()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	bc := &Broadcast{
+		Message:    BroadcastMessage{msg},
+		Status:     BroadcastRunning,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+
+	unique := make([]ChatID, 0, len(chatIDs))
+	seen := make(map[ChatID]bool, len(chatIDs))
+	for _, chatID := range chatIDs {
+		if !seen[chatID] {
+			seen[chatID] = true
+			unique = append(unique, chatID)
+		}
+	}
+
+	err = store.InsertBroadcast(bc, unique)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case b.broadcasts.wake <- struct{}{}:
+	default:
+	}
+
+	return bc, nil
+}
+
+// BroadcastPreview is the chats broadcast would be sent to,
+// see Bot.PreviewBroadcast.
+type BroadcastPreview struct {
+	Query   Query
+	ChatIDs []ChatID
+}
+
+// Count returns the number of chats.
+func (p BroadcastPreview) Count() int {
+	return len(p.ChatIDs)
+}
+
+// PreviewBroadcast resolves q to chats to broadcast to. Pass ChatIDs
+// to StartBroadcast to send to exactly these chats. Note that abandoned
+// chats match q unless it's WithAbandoned(false).
+func (b Bot) PreviewBroadcast(q Query) (BroadcastPreview, error) {
+	preview := BroadcastPreview{Query: q, ChatIDs: []ChatID{}}
+	if b.Config.Model == nil {
+		return preview, errNoBroadcastModel
+	}
+
+	chats, err := b.Config.Model.ChatsByQuery(q)
+	if err != nil {
+		return preview, err
+	}
+	for _, chat := range chats {
+		preview.ChatIDs = append(preview.ChatIDs, chat.ChatID)
+	}
+
+	return preview, nil
+}
+
+// StartBroadcastQuery starts broadcast of msg to chats matching q,
+// as StartBroadcast does.
+func (b Bot) StartBroadcastQuery(msg tgbotapi.Chattable, q Query) (*Broadcast, error) {
+	preview, err := b.PreviewBroadcast(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.StartBroadcast(msg, preview.ChatIDs)
+}
+
+// CancelBroadcast stops sending broadcast, its pending recipients stay pending.
+func (b Bot) CancelBroadcast(id int) error {
+	store, err := b.broadcastStore()
+	if err != nil {
+		return err
+	}
+
+	b.broadcasts.Lock()
+	defer b.broadcasts.Unlock()
+
+	bc, err := store.BroadcastByID(id)
+	if err != nil {
+		return err
+	}
+	if bc.Status != BroadcastRunning {
+		return fmt.Errorf("broadcast %v is %v", id, bc.Status)
+	}
+
+	bc.Status = BroadcastCanceled
+	bc.UpdateTime = time.Now()
+	err = store.UpdateBroadcast(bc)
+	if err != nil {
+		return err
+	}
+	b.broadcasts.canceled[id] = true
+
+	return nil
+}
+
+// BroadcastProgress returns broadcast with its recipients counts.
+func (b Bot) BroadcastProgress(id int) (BroadcastProgress, error) {
+	progress := BroadcastProgress{}
+	store, err := b.broadcastStore()
+	if err != nil {
+		return progress, err
+	}
+
+	bc, err := store.BroadcastByID(id)
+	if err != nil {
+		return progress, err
+	}
+	progress.Broadcast = *bc
+
+	recipients, err := store.RecipientsByBroadcastID(id)
+	if err != nil {
+		return progress, err
+	}
+	progress.Total = len(recipients)
+	for _, r := range recipients {
+		switch r.Status {
+		case RecipientPending:
+			progress.Pending++
+		case RecipientSent:
+			progress.Sent++
+		case RecipientFailed:
+			progress.Failed++
+		case RecipientBlocked:
+			progress.Blocked++
+		}
+	}
+
+	return progress, nil
+}
+
+// BroadcastReport returns broadcast recipients with their statuses and errors.
+func (b Bot) BroadcastReport(id int) ([]Recipient, error) {
+	store, err := b.broadcastStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.RecipientsByBroadcastID(id)
+}
+
+// goroutine
+func (b Bot) processBroadcasts() {
+	defer b.life.senders.Done()
+
+	store, err := b.broadcastStore()
+	if err != nil {
+		return
+	}
+
+	for {
+		retry, ok := b.runBroadcasts(store)
+		if !ok {
+			return
+		}
+
+		var retryChan <-chan time.Time
+		if retry {
+			retryChan = time.After(broadcastRetryDelay)
+		}
+		select {
+		case <-b.broadcasts.wake:
+		case <-retryChan:
+		case <-b.life.stopSenders:
+			return
+		}
+	}
+}
+
+// runBroadcasts sends running broadcasts until they are done.
+// Returns retry if some recipients are to be retried later,
+// and false ok if bot is stopping, the rest is sent after restart.
+func (b Bot) runBroadcasts(store BroadcastStore) (retry, ok bool) {
+	b.broadcasts.Lock()
+	bcs, err := store.BroadcastsByStatus(BroadcastRunning)
+	if err == nil {
+		// broadcasts canceled before they were loaded are not run
+		running := make(map[int]bool, len(bcs))
+		for _, bc := range bcs {
+			running[bc.ID] = true
+		}
+		for id := range b.broadcasts.canceled {
+			if !running[id] {
+				delete(b.broadcasts.canceled, id)
+			}
+		}
+	}
+	b.broadcasts.Unlock()
+	if err != nil {
+		log.Printf("Failed to load broadcasts: error \"%v\"\n", err)
+		return false, true
+	}
+
+	for _, bc := range bcs {
+		bcRetry, ok := b.runBroadcast(store, bc)
+		if !ok {
+			return false, false
+		}
+		retry = retry || bcRetry
+	}
+
+	return retry, true
+}
+
+// runBroadcast sends broadcast to its pending recipients, except ones
+// to be retried later. Its canceled mark is forgotten once it's observed
+// or broadcast is done.
+func (b Bot) runBroadcast(store BroadcastStore, bc *Broadcast) (retry, ok bool) {
+	recipients, err := store.RecipientsByBroadcastID(bc.ID)
+	if err != nil {
+		log.Printf("Failed to load broadcast %v: error \"%v\"\n", bc.ID, err)
+		return false, true
+	}
+
+	for _, r := range recipients {
+		if r.Status != RecipientPending {
+			continue
+		}
+		if r.Attempts > 0 && time.Since(r.Time) < broadcastRetryDelay {
+			retry = true
+			continue
+		}
+
+		select {
+		case <-b.life.stopSenders:
+			return false, false
+		default:
+		}
+
+		b.broadcasts.Lock()
+		canceled := b.broadcasts.canceled[bc.ID]
+		delete(b.broadcasts.canceled, bc.ID)
+		b.broadcasts.Unlock()
+		if canceled {
+			return false, true
+		}
+
+		_, err = b.send(bc.Message.Chattable, r.ChatID)
+		r.attempt(err, time.Now())
+		if err != nil {
+			log.Printf("Failed to broadcast %v to chat %v: error \"%v\"\n", bc.ID, r.ChatID, err)
+			if r.Status == RecipientBlocked {
+				b.abandonChat(r.ChatID)
+			}
+			if r.Status == RecipientPending {
+				retry = true
+			}
+		}
+
+		err = store.UpdateRecipient(bc.ID, r)
+		if err != nil {
+			log.Printf("Failed to save broadcast %v recipient %v: error \"%v\"\n", bc.ID, r.ChatID, err)
+		}
+	}
+
+	b.broadcasts.Lock()
+	defer b.broadcasts.Unlock()
+	if b.broadcasts.canceled[bc.ID] {
+		delete(b.broadcasts.canceled, bc.ID)
+		return false, true
+	}
+	if retry {
+		return true, true
+	}
+
+	bc.Status = BroadcastDone
+	bc.UpdateTime = time.Now()
+	err = store.UpdateBroadcast(bc)
+	if err != nil {
+		log.Printf("Failed to save broadcast %v: error \"%v\"\n", bc.ID, err)
+	}
+
+	return false, true
+}
+
+// chatAbandoned signal tells chat goroutine that its chat
+// blocked the bot.
+type chatAbandoned struct{}
+
+// abandonChat marks chat Abandoned through its goroutine if it's running,
+// so that goroutine's copy of chat is not saved over.
+func (b Bot) abandonChat(chatID ChatID) {
+	b.chatsChans.RLock()
+	chatChan := b.chatsChans.m[chatKey{ChatID: chatID}]
+	sent := chatChan != nil && b.signalChat(chatChan, chatAbandoned{})
+	b.chatsChans.RUnlock()
+	if sent {
+		return
+	}
+
+	if b.Config.Model == nil {
+		return
+	}
+	err := b.UpdateGroupChat(chatID, func(chat *Chat) {
+		chat.Abandoned = true
+	})
+	if err != nil {
+		log.Printf("Failed to abandon chat %v: error \"%v\"\n", chatID, err)
+	}
+}
@@ -0,0 +1,94 @@
+package depechebot
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// broadcastStoreStub keeps running broadcasts without recipients.
+type broadcastStoreStub struct {
+	running []*Broadcast
+	updated []*Broadcast
+}
+
+func (s *broadcastStoreStub) InsertBroadcast(bc *Broadcast, chatIDs []ChatID) error {
+	return nil
+}
+
+func (s *broadcastStoreStub) UpdateBroadcast(bc *Broadcast) error {
+	s.updated = append(s.updated, bc)
+	return nil
+}
+
+func (s *broadcastStoreStub) BroadcastByID(id int) (*Broadcast, error) {
+	return nil, nil
+}
+
+func (s *broadcastStoreStub) BroadcastsByStatus(status BroadcastStatus) ([]*Broadcast, error) {
+	return s.running, nil
+}
+
+func (s *broadcastStoreStub) UpdateRecipient(id int, r Recipient) error {
+	return nil
+}
+
+func (s *broadcastStoreStub) RecipientsByBroadcastID(id int) ([]Recipient, error) {
+	return nil, nil
+}
+
+func TestRunBroadcastsForgetsCanceled(t *testing.T) {
+	b := Bot{broadcasts: newBroadcasts()}
+	store := &broadcastStoreStub{running: []*Broadcast{{ID: 1, Status: BroadcastRunning}}}
+
+	// 1 is canceled while running, 2 before it's loaded
+	b.broadcasts.canceled[1] = true
+	b.broadcasts.canceled[2] = true
+	if _, ok := b.runBroadcasts(store); !ok {
+		t.Fatal("runBroadcasts returned false")
+	}
+
+	if len(b.broadcasts.canceled) != 0 {
+		t.Errorf("canceled broadcasts are kept: %v", b.broadcasts.canceled)
+	}
+	if len(store.updated) != 0 {
+		t.Errorf("canceled broadcast is updated: %+v", store.updated[0])
+	}
+
+	if _, ok := b.runBroadcasts(store); !ok {
+		t.Fatal("runBroadcasts returned false")
+	}
+	if len(store.updated) != 1 || store.updated[0].Status != BroadcastDone {
+		t.Errorf("broadcast is not done: %+v", store.updated)
+	}
+}
+
+func TestRecipientAttempt(t *testing.T) {
+	now := time.Now()
+	flood := &APIError{Kind: ErrFloodWait, Err: errors.New("Too Many Requests")}
+
+	r := Recipient{ChatID: 1, Status: RecipientPending}
+	for i := 1; i < broadcastAttempts; i++ {
+		r.attempt(flood, now)
+		if r.Status != RecipientPending || r.Attempts != i || r.Error == "" {
+			t.Fatalf("recipient after %v temporary errors: %+v", i, r)
+		}
+	}
+	r.attempt(flood, now)
+	if r.Status != RecipientFailed {
+		t.Errorf("recipient after %v temporary errors: %+v", broadcastAttempts, r)
+	}
+
+	r = Recipient{ChatID: 1, Status: RecipientPending}
+	r.attempt(flood, now)
+	r.attempt(nil, now)
+	if r.Status != RecipientSent || r.Error != "" || r.Attempts != 2 {
+		t.Errorf("recipient after retry: %+v", r)
+	}
+
+	r = Recipient{ChatID: 1, Status: RecipientPending}
+	r.attempt(&APIError{Kind: ErrBlocked, Err: errors.New("Forbidden")}, now)
+	if r.Status != RecipientBlocked {
+		t.Errorf("blocked recipient: %+v", r)
+	}
+}
@@ -0,0 +1,143 @@
+// Command dbotgraph renders states of a bot package as Graphviz
+// or Mermaid diagram, see depechebot.WriteStatesGraph. States are
+// exported variable or function of the package, e.g.
+//
+//	dbotgraph github.com/me/mybot States | dot -Tsvg > states.svg
+//	dbotgraph -format mermaid -o states.mmd github.com/me/mybot 'PrivateStates()'
+//
+// It builds and runs a program importing the package, so it should be run
+// where "go build" of the package works, e.g. in its module. Package main
+// can't be imported, so bot's states should be declared in another package,
+// e.g. github.com/me/mybot/states imported by the bot's command.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var program = template.Must(template.New("").Parse(`package main
+
+import (
+	"log"
+	"os"
+
+	dbot "github.com/depechebot/depechebot"
+	bot {{printf "%q" .Package}}
+)
+
+func main() {
+	err := dbot.WriteStatesGraph(os.Stdout, bot.{{.States}}, {{printf "%q" .Format}})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+`))
+
+func main() {
+	format := flag.String("format", "dot", "graph format: dot or mermaid")
+	output := flag.String("o", "", "output file, stdout if empty")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: dbotgraph [flags] package states\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(flag.Arg(0), flag.Arg(1), *format, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// statesExpr is exported variable or function call without arguments,
+// it's pasted into generated program as is.
+var statesExpr = regexp.MustCompile(`^[A-Z][A-Za-z0-9_]*(\(\))?$`)
+
+func run(pkg, states, format, output string) error {
+	if !statesExpr.MatchString(states) {
+		return fmt.Errorf("states %q should be exported variable or function name, e.g. States or 'States()'", states)
+	}
+
+	name, importPath, err := resolvePackage(pkg)
+	if err != nil {
+		return err
+	}
+	if name == "main" {
+		return fmt.Errorf("%v is package main, which can't be imported: declare states in another package", pkg)
+	}
+
+	// in current directory, so that the package is resolved by its module
+	dir, err := ioutil.TempDir(".", "dbotgraph")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, "main.go"))
+	if err != nil {
+		return err
+	}
+	err = program.Execute(f, struct{ Package, States, Format string }{importPath, states, format})
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return render(dir, os.Stdout)
+	}
+
+	// output is replaced only if graph is rendered
+	out, err := ioutil.TempFile(filepath.Dir(output), filepath.Base(output)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	err = render(dir, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), output)
+}
+
+// render runs program generated in dir, writing graph to out.
+func render(dir string, out *os.File) error {
+	cmd := exec.Command("go", "run", filepath.Join(dir, "main.go"))
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// resolvePackage returns name and import path of package pkg by go list,
+// pkg could be relative, e.g. "./states".
+func resolvePackage(pkg string) (name, importPath string, err error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", "list", "-f", "{{.Name}} {{.ImportPath}}", pkg)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("go list %v: %v: %s", pkg, err, strings.TrimSpace(stderr.String()))
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("go list %v: unexpected output %q", pkg, out)
+	}
+	return fields[0], fields[1], nil
+}
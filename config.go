@@ -7,9 +7,10 @@ import (
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
 
-//type LanguageType string
+// Request is a reply keyboard button or message text. Its Text is
+// in Config.DefaultLanguage and is localized by Config.Catalog.
 type Request struct {
-	Text         string //map[LanguageType]string
+	Text         string
 	unprescribed bool
 }
 type ResponseFunc func(Bot, Chat, tgbotapi.Update, *State, *Params)
@@ -18,14 +19,27 @@ type Responser interface {
 }
 type Responsers []Responser
 type ReqToRes map[Request]Responser
+type CallbackToRes map[Request]Responser
+
+// InlineButton is a button of inline keyboard. Pressed button with
+// callback data is dispatched back to the chat as callback query, which
+// could be matched with CallbackToRes.
+type InlineButton struct {
+	Text              string
+	Data              string
+	URL               string
+	SwitchInlineQuery string
+}
 
 type Text struct {
 	Text      string
 	ParseMode string
+	Keyboard  interface{}
 }
 type Photo struct {
-	Caption string
-	FileID  string
+	Caption  string
+	FileID   string
+	Keyboard interface{}
 }
 type Document struct {
 	FileID string
@@ -34,6 +48,17 @@ type StateActions struct {
 	Before func(Bot, Chat)
 	While  func(Bot, <-chan Signal) Signal
 	After  func(Bot, Chat, tgbotapi.Update, *State, *Params)
+	// Responser is run instead of After, if it's nil, e.g. StateResponser.
+	// Unlike After, its transitions are checked by ValidateStates.
+	Responser Responser
+}
+
+// after returns After or Responser's Response.
+func (a StateActions) after() func(Bot, Chat, tgbotapi.Update, *State, *Params) {
+	if a.After == nil && a.Responser != nil {
+		return a.Responser.Response
+	}
+	return a.After
 }
 
 func NewText(s string) Text {
@@ -56,6 +81,62 @@ func NewDocument(s string) Document {
 	return Document{FileID: s}
 }
 
+// MaxCallbackDataLen is Telegram's limit of callback data length in bytes.
+const MaxCallbackDataLen = 64
+
+// NewInlineButton returns button sending data as callback query.
+// If data is empty, button text is used instead, so buttons built
+// from request texts match the same CallbackToRes requests.
+// It panics if data is longer than MaxCallbackDataLen.
+func NewInlineButton(text, data string) InlineButton {
+	return InlineButton{Text: text, Data: data}.mustFit()
+}
+
+func NewInlineButtonURL(text, url string) InlineButton {
+	return InlineButton{Text: text, URL: url}
+}
+
+func NewInlineButtonSwitch(text, query string) InlineButton {
+	return InlineButton{Text: text, SwitchInlineQuery: query}
+}
+
+// NewInlineRequest returns inline button for request r,
+// it panics if r.Text is longer than MaxCallbackDataLen.
+func NewInlineRequest(r Request) InlineButton {
+	return InlineButton{Text: r.Text}.mustFit()
+}
+
+// callbackData returns data sent by pressed button, empty for URL
+// and switch buttons.
+func (button InlineButton) callbackData() string {
+	if button.URL != "" || button.SwitchInlineQuery != "" {
+		return ""
+	}
+	if button.Data == "" {
+		return button.Text
+	}
+	return button.Data
+}
+
+func (button InlineButton) mustFit() InlineButton {
+	if data := button.callbackData(); len(data) > MaxCallbackDataLen {
+		log.Panicf("Callback data %q is %v bytes, longer than %v", data, len(data), MaxCallbackDataLen)
+	}
+	return button
+}
+
+func (text Text) WithKeyboard(keyboard interface{}) Text {
+	newText := text
+	newText.Keyboard = keyboard
+	return newText
+}
+
+func (photo Photo) WithKeyboard(keyboard interface{}) Photo {
+	newPhoto := photo
+	newPhoto.Keyboard = keyboard
+	return newPhoto
+}
+
 func NewState(s string) State {
 	return State{
 		Name:   StateName(s),
@@ -128,34 +209,55 @@ func (p Params) With(key, value string) Params {
 
 var (
 	StartState = NewState("START")
+	// universalState is set by UniversalResponse
+	universalState = NewState("MAIN")
 )
 
 func UniversalResponse(chat Chat, update tgbotapi.Update, state *State, params *Params) {
 	//*state = StartState
 	// todo: fixme!!! Need to initialize UniversalResponse in config
-	*state = NewState("MAIN")
+	*state = NewState(string(universalState.Name))
 }
 
 func StateBefore(text Text, keyboard interface{}) func(bot Bot, chat Chat) {
 	return func(bot Bot, chat Chat) {
-		msg := tgbotapi.NewMessage(int64(chat.ChatID), text.Text)
+		localize := bot.localizer(chat)
+		msg := tgbotapi.NewMessage(int64(chat.ChatID), localize(text.Text))
 		msg.ParseMode = text.ParseMode
-		switch keyboard := keyboard.(type) {
-		default:
-			msg.ReplyMarkup = keyboard
-		case [][]Request:
-			msg.ReplyMarkup = Keyboard(keyboard)
-		case []Request:
-			msg.ReplyMarkup = Keyboard([][]Request{keyboard})
-		case Request:
-			if keyboard == NewUnprescribedRequest() {
-				msg.ReplyMarkup = tgbotapi.ReplyKeyboardHide{HideKeyboard: true}
-			} else {
-				msg.ReplyMarkup = Keyboard([][]Request{{keyboard}})
-			}
+		markup, err := replyMarkup(keyboard, localize)
+		if err != nil {
+			log.Printf("Failed to send to chat %v: error \"%v\"\n", chat.ChatID, err)
+			return
 		}
+		msg.ReplyMarkup = markup
+
+		bot.SendChan <- chatSignal(chat, msg)
+	}
+}
 
-		bot.SendChan <- ChatSignal{msg, chat.ChatID}
+// replyMarkup converts keyboard given as Request(s) or InlineButton(s)
+// to the tgbotapi reply markup with texts localized. Other values
+// are returned as is. Inline keyboard with too long callback data
+// can't be sent, so it's an error.
+func replyMarkup(keyboard interface{}, localize func(string) string) (interface{}, error) {
+	switch keyboard := keyboard.(type) {
+	default:
+		return keyboard, nil
+	case [][]Request:
+		return localizedKeyboard(keyboard, localize), nil
+	case []Request:
+		return localizedKeyboard([][]Request{keyboard}, localize), nil
+	case Request:
+		if keyboard == NewUnprescribedRequest() {
+			return tgbotapi.ReplyKeyboardHide{HideKeyboard: true}, nil
+		}
+		return localizedKeyboard([][]Request{{keyboard}}, localize), nil
+	case [][]InlineButton:
+		return localizedInlineKeyboard(keyboard, localize)
+	case []InlineButton:
+		return localizedInlineKeyboard([][]InlineButton{keyboard}, localize)
+	case InlineButton:
+		return localizedInlineKeyboard([][]InlineButton{{keyboard}}, localize)
 	}
 }
 
@@ -169,6 +271,11 @@ func StateAfter(responsers ...Responser) func(Bot, Chat, tgbotapi.Update, *State
 	return Responsers(responsers).Response
 }
 
+// StateResponser is StateAfter for StateActions.Responser.
+func StateResponser(responsers ...Responser) Responser {
+	return Responsers(responsers)
+}
+
 func (responsers Responsers) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
 	for _, responser := range responsers {
 		responser.Response(bot, chat, update, state, params)
@@ -176,24 +283,52 @@ func (responsers Responsers) Response(bot Bot, chat Chat, update tgbotapi.Update
 }
 
 func (text Text) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
-	if text.Text != "" {
-		msg := tgbotapi.NewMessage(int64(chat.ChatID), text.Text)
-		msg.ParseMode = text.ParseMode
-		bot.SendChan <- ChatSignal{msg, chat.ChatID}
+	localize := bot.localizer(chat)
+	sendText(bot, chat, localize(text.Text), text.ParseMode, text.Keyboard, localize)
+}
+
+// sendText sends already localized s with keyboard.
+func sendText(bot Bot, chat Chat, s, parseMode string, keyboard interface{}, localize func(string) string) {
+	if s != "" {
+		msg := tgbotapi.NewMessage(int64(chat.ChatID), s)
+		msg.ParseMode = parseMode
+		if keyboard != nil {
+			markup, err := replyMarkup(keyboard, localize)
+			if err != nil {
+				log.Printf("Failed to send to chat %v: error \"%v\"\n", chat.ChatID, err)
+				return
+			}
+			msg.ReplyMarkup = markup
+		}
+		bot.SendChan <- chatSignal(chat, msg)
 	}
 }
 
 func (photo Photo) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
-	msg := tgbotapi.NewPhotoShare(int64(chat.ChatID), photo.FileID)
-	if photo.Caption != "" {
-		msg.Caption = photo.Caption
+	localize := bot.localizer(chat)
+	sendPhoto(bot, chat, photo.FileID, localize(photo.Caption), photo.Keyboard, localize)
+}
+
+// sendPhoto sends photo with already localized caption and keyboard.
+func sendPhoto(bot Bot, chat Chat, fileID, caption string, keyboard interface{}, localize func(string) string) {
+	msg := tgbotapi.NewPhotoShare(int64(chat.ChatID), fileID)
+	if caption != "" {
+		msg.Caption = caption
 	}
-	bot.SendChan <- ChatSignal{msg, chat.ChatID}
+	if keyboard != nil {
+		markup, err := replyMarkup(keyboard, localize)
+		if err != nil {
+			log.Printf("Failed to send to chat %v: error \"%v\"\n", chat.ChatID, err)
+			return
+		}
+		msg.ReplyMarkup = markup
+	}
+	bot.SendChan <- chatSignal(chat, msg)
 }
 
 func (d Document) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
 	msg := tgbotapi.NewDocumentShare(int64(chat.ChatID), d.FileID)
-	bot.SendChan <- ChatSignal{msg, chat.ChatID}
+	bot.SendChan <- chatSignal(chat, msg)
 }
 
 func (newState State) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
@@ -204,14 +339,40 @@ func (newParams Params) Response(bot Bot, chat Chat, update tgbotapi.Update, sta
 	params.AddParams(newParams)
 }
 
+// Response matches message text, localized button labels match
+// their requests. Updates without message (e.g. callback queries)
+// are left for CallbackToRes.
 func (responses ReqToRes) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
-	response, ok := responses[NewRequest(update.Message.Text)]
+	if update.Message == nil {
+		return
+	}
+	responseByRequest(responses, update.Message.Text, bot, chat, update, state, params)
+}
+
+// Response matches callback data of pressed inline keyboard button.
+// Updates without callback query are left for ReqToRes.
+func (responses CallbackToRes) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
+	if update.CallbackQuery == nil {
+		return
+	}
+	responseByRequest(responses, update.CallbackQuery.Data, bot, chat, update, state, params)
+}
+
+func responseByRequest(responses map[Request]Responser, text string,
+	bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
+
+	response, ok := responses[NewRequest(text)]
+	if !ok {
+		if request, found := bot.catalog.request(bot.Language(chat), text); found {
+			response, ok = responses[NewRequest(request)]
+		}
+	}
 	if !ok {
 		response, ok = responses[NewUnprescribedRequest()]
 		if !ok {
 			//response = UniversalResponse
 			UniversalResponse(chat, update, state, params)
-			log.Printf("no response %v in responses %v\n", update.Message.Text, responses)
+			log.Printf("no response %v in responses %v\n", text, responses)
 			return
 		}
 	}
@@ -224,13 +385,60 @@ func (responseFunc ResponseFunc) Response(bot Bot, chat Chat, update tgbotapi.Up
 }
 
 func Keyboard(keyboard [][]Request) tgbotapi.ReplyKeyboardMarkup {
+	return localizedKeyboard(keyboard, func(text string) string { return text })
+}
+
+func localizedKeyboard(keyboard [][]Request, localize func(string) string) tgbotapi.ReplyKeyboardMarkup {
 	var Keyboard [][]tgbotapi.KeyboardButton
 	for _, row := range keyboard {
 		var Row []tgbotapi.KeyboardButton
 		for _, button := range row {
-			Row = append(Row, tgbotapi.NewKeyboardButton(button.Text))
+			Row = append(Row, tgbotapi.NewKeyboardButton(localize(button.Text)))
 		}
 		Keyboard = append(Keyboard, Row)
 	}
 	return tgbotapi.NewReplyKeyboard(Keyboard...)
 }
+
+// InlineKeyboard returns markup of keyboard, it panics if callback data
+// of some button is longer than MaxCallbackDataLen.
+func InlineKeyboard(keyboard [][]InlineButton) tgbotapi.InlineKeyboardMarkup {
+	markup, err := localizedInlineKeyboard(keyboard, func(text string) string { return text })
+	if err != nil {
+		log.Panic(err)
+	}
+	return markup
+}
+
+// localizedInlineKeyboard localizes buttons texts, callback data
+// stays the same, so it matches CallbackToRes in any language.
+// Telegram rejects the message with too long callback data, so it's an error.
+func localizedInlineKeyboard(keyboard [][]InlineButton, localize func(string) string) (tgbotapi.InlineKeyboardMarkup, error) {
+	var Keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, row := range keyboard {
+		var Row []tgbotapi.InlineKeyboardButton
+		for _, button := range row {
+			button.Data = button.callbackData()
+			if len(button.Data) > MaxCallbackDataLen {
+				return tgbotapi.InlineKeyboardMarkup{}, fmt.Errorf("callback data %q is %v bytes, longer than %v",
+					button.Data, len(button.Data), MaxCallbackDataLen)
+			}
+			button.Text = localize(button.Text)
+			Row = append(Row, button.keyboardButton())
+		}
+		Keyboard = append(Keyboard, Row)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(Keyboard...), nil
+}
+
+func (button InlineButton) keyboardButton() tgbotapi.InlineKeyboardButton {
+	switch {
+	case button.URL != "":
+		return tgbotapi.NewInlineKeyboardButtonURL(button.Text, button.URL)
+	case button.SwitchInlineQuery != "":
+		return tgbotapi.NewInlineKeyboardButtonSwitch(button.Text, button.SwitchInlineQuery)
+	case button.Data != "":
+		return tgbotapi.NewInlineKeyboardButtonData(button.Text, button.Data)
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(button.Text, button.Text)
+}
@@ -0,0 +1,106 @@
+package depechebot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestCallbackToRes(t *testing.T) {
+	responses := Responsers{
+		ReqToRes{NewRequest("Help"): NewState("HELP")},
+		CallbackToRes{
+			NewRequest("go"):         NewState("GO"),
+			NewUnprescribedRequest(): NewState("OTHER"),
+		},
+	}
+	callback := func(data string) tgbotapi.Update {
+		return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Data: data}}
+	}
+
+	tests := []struct {
+		update tgbotapi.Update
+		want   StateName
+	}{
+		{callback("go"), "GO"},
+		{callback("Help"), "OTHER"},
+		{tgbotapi.Update{Message: &tgbotapi.Message{Text: "Help"}}, "HELP"},
+		// messages are left for ReqToRes
+		{tgbotapi.Update{Message: &tgbotapi.Message{Text: "go"}}, "MAIN"},
+	}
+	for _, test := range tests {
+		state := NewState("START")
+		params := Params{}
+		responses.Response(Bot{}, Chat{}, test.update, &state, &params)
+		if state.Name != test.want {
+			t.Errorf("state after %+v is %v, want %v", test.update, state.Name, test.want)
+		}
+	}
+}
+
+func TestInlineKeyboard(t *testing.T) {
+	keyboard := InlineKeyboard([][]InlineButton{
+		{NewInlineButton("Go", "go"), NewInlineRequest(NewRequest("Help"))},
+		{NewInlineButtonURL("Site", "https://example.com"), NewInlineButtonSwitch("Share", "song")},
+	})
+
+	buttons := keyboard.InlineKeyboard
+	if len(buttons) != 2 || len(buttons[0]) != 2 || len(buttons[1]) != 2 {
+		t.Fatalf("keyboard is %+v, want 2x2", buttons)
+	}
+	if b := buttons[0][0]; b.Text != "Go" || b.CallbackData == nil || *b.CallbackData != "go" {
+		t.Errorf("data button is %+v", b)
+	}
+	// request's text is its callback data
+	if b := buttons[0][1]; b.Text != "Help" || b.CallbackData == nil || *b.CallbackData != "Help" {
+		t.Errorf("request button is %+v", b)
+	}
+	if b := buttons[1][0]; b.URL == nil || *b.URL != "https://example.com" || b.CallbackData != nil {
+		t.Errorf("URL button is %+v", b)
+	}
+	if b := buttons[1][1]; b.SwitchInlineQuery == nil || *b.SwitchInlineQuery != "song" {
+		t.Errorf("switch button is %+v", b)
+	}
+}
+
+func TestInlineButtonDataLimit(t *testing.T) {
+	long := strings.Repeat("я", MaxCallbackDataLen/2+1)
+
+	for _, build := range []func(){
+		func() { NewInlineButton("Go", long) },
+		func() { NewInlineRequest(NewRequest(long)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic on long callback data")
+				}
+			}()
+			build()
+		}()
+	}
+
+	// buttons built by hand are checked when sent
+	_, err := replyMarkup(InlineButton{Text: "Go", Data: long}, func(text string) string { return text })
+	if err == nil {
+		t.Error("no error on long callback data of keyboard")
+	}
+	_, err = replyMarkup([]InlineButton{{Text: long}, {Text: long, URL: "https://example.com"}}, strings.ToUpper)
+	if err == nil {
+		t.Error("no error on long text of inline request")
+	}
+
+	// the text of URL button is not sent back
+	NewInlineButton(long, "go")
+	NewInlineButtonURL(long, "https://example.com")
+
+	err = ValidateStates(map[StateName]StateActions{
+		"START": {Responser: StateResponser(CallbackToRes{NewRequest(long): NewText("Hi"), NewUnprescribedRequest(): StartState})},
+	})
+	want := fmt.Sprintf("invalid states config:\n\tstate START: callback %q is longer than 64 bytes, it never matches", long)
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
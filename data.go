@@ -0,0 +1,91 @@
+package depechebot
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Data stores chat's values of any JSON-serialisable types, unlike string Params.
+// Map is shared between copies of Chat, so state handlers can change
+// chat.Data directly, it's saved with the chat.
+//
+//	var cart []string
+//	_, err := chat.Data.Get("cart", &cart)
+//	cart = append(cart, item)
+//	err = chat.Data.Set("cart", cart)
+type Data map[string]json.RawMessage
+
+// Get decodes value of key into v, as json.Unmarshal does.
+// ok is false if there is no such key, v is left untouched then.
+func (d Data) Get(key string, v interface{}) (ok bool, err error) {
+	raw, ok := d[key]
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, v)
+}
+
+// Set encodes v as value of key.
+func (d Data) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	d[key] = raw
+	return nil
+}
+
+func (d Data) Has(key string) bool {
+	_, ok := d[key]
+	return ok
+}
+
+func (d Data) Delete(key string) {
+	delete(d, key)
+}
+
+// Keys returns sorted keys.
+func (d Data) Keys() []string {
+	keys := make([]string, 0, len(d))
+	for key := range d {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetString returns string value of key, or "" if it's missing or not a string.
+func (d Data) GetString(key string) string {
+	var s string
+	d.Get(key, &s)
+	return s
+}
+
+// GetInt returns integer value of key, or 0 if it's missing or not an integer.
+func (d Data) GetInt(key string) int {
+	var i int
+	d.Get(key, &i)
+	return i
+}
+
+// GetBool returns boolean value of key, or false if it's missing or not a boolean.
+func (d Data) GetBool(key string) bool {
+	var b bool
+	d.Get(key, &b)
+	return b
+}
+
+// Clone returns deep copy of data.
+func (d Data) Clone() Data {
+	if d == nil {
+		return nil
+	}
+
+	newData := Data{}
+	for key, value := range d {
+		newData[key] = append(json.RawMessage{}, value...)
+	}
+	return newData
+}
@@ -0,0 +1,44 @@
+package depechebot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestData(t *testing.T) {
+	d := Data{}
+
+	var list []int
+	ok, err := d.Get("list", &list)
+	if ok || err != nil {
+		t.Errorf("Get of missing key returned %v, %v", ok, err)
+	}
+
+	err = d.Set("list", []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set("name", "Dave")
+
+	ok, err = d.Get("list", &list)
+	if !ok || err != nil || !reflect.DeepEqual(list, []int{1, 2}) {
+		t.Errorf("Get returned %v, %v, %v", list, ok, err)
+	}
+	if d.GetInt("name") != 0 || d.GetString("name") != "Dave" {
+		t.Errorf("typed getters returned %v, %q", d.GetInt("name"), d.GetString("name"))
+	}
+
+	clone := d.Clone()
+	clone.Delete("name")
+	if !d.Has("name") || clone.Has("name") {
+		t.Error("Clone shares data with original")
+	}
+	if !reflect.DeepEqual(d.Keys(), []string{"list", "name"}) {
+		t.Errorf("got keys %v", d.Keys())
+	}
+
+	err = d.Set("func", func() {})
+	if err == nil {
+		t.Error("Set of unserialisable value returned no error")
+	}
+}
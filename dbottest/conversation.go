@@ -0,0 +1,248 @@
+package dbottest
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	dbot "github.com/depechebot/depechebot"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Input is a user action in conversation script.
+type Input struct {
+	text   string
+	data   string
+	isData bool
+	signal dbot.Signal
+}
+
+// Say is a text message (or reply keyboard button press) from user.
+func Say(text string) Input {
+	return Input{text: text}
+}
+
+// Press is a press of inline keyboard button with callback data.
+func Press(data string) Input {
+	return Input{data: data, isData: true}
+}
+
+// Interrupt interrupts chat with state, as one does through Bot.SendChan.
+func Interrupt(state dbot.State) Input {
+	return Input{signal: state}
+}
+
+// Signal is arbitrary signal, e.g. tgbotapi.Update.
+func Signal(signal dbot.Signal) Input {
+	return Input{signal: signal}
+}
+
+// Event is either a message sent by the bot or a state entered by the chat.
+type Event struct {
+	Sent  tgbotapi.Chattable // nil for state events
+	State dbot.State
+}
+
+func (e Event) String() string {
+	if e.Sent != nil {
+		return fmt.Sprintf("sent %v", describe(e.Sent))
+	}
+	return fmt.Sprintf("entered %v", e.State)
+}
+
+// Result of the conversation script.
+type Result struct {
+	Events []Event
+	// Chat after conversation.
+	Chat dbot.Chat
+	// Unreached are states never entered during conversation
+	// (the starting state counts as entered).
+	Unreached []dbot.StateName
+	// Panic is recovered panic value, nil if states didn't panic.
+	Panic interface{}
+	// Stack is stack trace of the panic.
+	Stack string
+}
+
+// Conversation runs state machine of one chat without network:
+// script of user inputs is passed through the same Before/While/After
+// cycle the bot uses for every chat.
+type Conversation struct {
+	// States is StatesConfigPrivate or StatesConfigGroup.
+	States map[dbot.StateName]dbot.StateActions
+	// Chat is the starting chat. Its State is dbot.StartState if empty.
+	Chat dbot.Chat
+	// Model, if set, is used to save chat after every step.
+	// Chat is inserted to Model if it doesn't exist.
+	Model dbot.Model
+	// Timeout limits conversation time, DefaultTimeout if zero.
+	Timeout time.Duration
+}
+
+// Run runs conversation script. Panics of state handlers are reported
+// in Result, error is returned if chat could not be saved to Model
+// or conversation is not finished in time.
+func (c Conversation) Run(inputs ...Input) (Result, error) {
+	chat := c.Chat
+	if chat.State.Name == "" {
+		chat.State = dbot.StartState
+	}
+	if chat.Params == nil {
+		chat.Params = dbot.Params{}
+	}
+	if chat.Type == "" {
+		chat.Type = "private"
+	}
+	if chat.OpenTime.IsZero() {
+		chat.OpenTime = time.Now()
+		chat.LastTime = chat.OpenTime
+	}
+
+	if c.Model != nil {
+		err := c.Model.Save(&chat)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	signals := make(chan dbot.Signal, len(inputs))
+	for _, input := range inputs {
+		signals <- input.signalFor(chat)
+	}
+	close(signals)
+
+	// events are appended by state goroutine, which could be
+	// still running if conversation is timed out
+	var mu sync.Mutex
+	result := Result{}
+	reached := map[dbot.StateName]bool{chat.State.Name: true}
+	config := dbot.Config{
+		StatesConfigPrivate: c.States,
+		StatesConfigGroup:   c.States,
+		Model:               c.Model,
+		StateLog: func(bot dbot.Bot, chat dbot.Chat) {
+			mu.Lock()
+			reached[chat.State.Name] = true
+			result.Events = append(result.Events, Event{State: chat.State})
+			mu.Unlock()
+		},
+	}
+	sent := func(msg tgbotapi.Chattable) {
+		mu.Lock()
+		result.Events = append(result.Events, Event{Sent: msg})
+		mu.Unlock()
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				mu.Lock()
+				result.Panic = r
+				result.Stack = string(debug.Stack())
+				mu.Unlock()
+			}
+		}()
+		dbot.RunChat(config, &chat, signals, sent)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		// state goroutine is leaked, e.g. While doesn't return on closed channel
+		mu.Lock()
+		defer mu.Unlock()
+		return Result{Events: append([]Event(nil), result.Events...)},
+			fmt.Errorf("dbottest: conversation is not finished in %v", timeout)
+	}
+
+	result.Chat = chat
+	for name := range c.States {
+		if !reached[name] {
+			result.Unreached = append(result.Unreached, name)
+		}
+	}
+	sort.Slice(result.Unreached, func(i, j int) bool {
+		return result.Unreached[i] < result.Unreached[j]
+	})
+
+	return result, nil
+}
+
+func (in Input) signalFor(chat dbot.Chat) dbot.Signal {
+	if in.signal != nil {
+		return in.signal
+	}
+
+	tgChat := &tgbotapi.Chat{ID: int64(chat.ChatID), Type: chat.Type}
+	from := &tgbotapi.User{
+		ID:        chat.UserID,
+		UserName:  chat.UserName,
+		FirstName: chat.FirstName,
+		LastName:  chat.LastName,
+	}
+	message := &tgbotapi.Message{
+		From: from,
+		Chat: tgChat,
+		Date: int(time.Now().Unix()),
+	}
+
+	if in.isData {
+		return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			From:    from,
+			Message: message,
+			Data:    in.data,
+		}}
+	}
+
+	message.Text = in.text
+	return tgbotapi.Update{Message: message}
+}
+
+// Texts returns texts of messages (and captions of photos) sent by the bot.
+func (r Result) Texts() []string {
+	var texts []string
+	for _, event := range r.Events {
+		if event.Sent == nil {
+			continue
+		}
+		switch msg := event.Sent.(type) {
+		case tgbotapi.MessageConfig:
+			texts = append(texts, msg.Text)
+		case tgbotapi.PhotoConfig:
+			texts = append(texts, msg.Caption)
+		}
+	}
+	return texts
+}
+
+// States returns states entered by the chat in order.
+func (r Result) States() []dbot.StateName {
+	var states []dbot.StateName
+	for _, event := range r.Events {
+		if event.Sent == nil {
+			states = append(states, event.State.Name)
+		}
+	}
+	return states
+}
+
+func describe(msg tgbotapi.Chattable) string {
+	switch msg := msg.(type) {
+	case tgbotapi.MessageConfig:
+		return fmt.Sprintf("message %q", msg.Text)
+	case tgbotapi.PhotoConfig:
+		return fmt.Sprintf("photo %v %q", msg.FileID, msg.Caption)
+	case tgbotapi.DocumentConfig:
+		return fmt.Sprintf("document %v", msg.FileID)
+	}
+	return fmt.Sprintf("%T", msg)
+}
@@ -0,0 +1,120 @@
+package dbottest
+
+import (
+	"reflect"
+	"testing"
+
+	dbot "github.com/depechebot/depechebot"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+var testStates = map[dbot.StateName]dbot.StateActions{
+	"START": {
+		While: dbot.StateWhile(),
+		After: dbot.StateAfter(dbot.NewState("MAIN")),
+	},
+	"MAIN": {
+		Before: dbot.StateBefore(dbot.NewText("Main menu"), []dbot.Request{dbot.NewRequest("Name")}),
+		While:  dbot.StateWhile(),
+		After: dbot.StateAfter(
+			dbot.ReqToRes{
+				dbot.NewRequest("Name"):  dbot.NewState("NAME"),
+				dbot.NewRequest("Crash"): dbot.NewState("CRASH"),
+			},
+			dbot.CallbackToRes{dbot.NewRequest("name"): dbot.NewState("NAME")},
+		),
+	},
+	"NAME": {
+		Before: dbot.StateBefore(dbot.NewText("What's your name?"), dbot.NewUnprescribedRequest()),
+		While:  dbot.StateWhile(),
+		After: dbot.StateAfter(
+			dbot.ResponseFunc(func(bot dbot.Bot, chat dbot.Chat, update tgbotapi.Update, state *dbot.State, params *dbot.Params) {
+				params.Set("name", update.Message.Text)
+				chat.Data.Set("names", append(namesOf(chat), update.Message.Text))
+			}),
+			dbot.NewText("Nice to meet you"),
+			dbot.NewState("MAIN"),
+		),
+	},
+	"CRASH": {
+		Before: func(dbot.Bot, dbot.Chat) {
+			panic("crash")
+		},
+	},
+	"UNUSED": {},
+}
+
+func namesOf(chat dbot.Chat) []string {
+	var names []string
+	chat.Data.Get("names", &names)
+	return names
+}
+
+func TestConversation(t *testing.T) {
+	conversation := Conversation{
+		States: testStates,
+		Chat:   dbot.Chat{ChatID: 42, UserID: 42, FirstName: "Dave"},
+	}
+
+	result, err := conversation.Run(Say("/start"), Say("Name"), Say("Dave"), Press("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Panic != nil {
+		t.Fatalf("unexpected panic: %v\n%v", result.Panic, result.Stack)
+	}
+
+	texts := []string{"Main menu", "What's your name?", "Nice to meet you", "Main menu", "What's your name?"}
+	if !reflect.DeepEqual(result.Texts(), texts) {
+		t.Errorf("got texts %q, want %q", result.Texts(), texts)
+	}
+	states := []dbot.StateName{"MAIN", "NAME", "MAIN", "NAME"}
+	if !reflect.DeepEqual(result.States(), states) {
+		t.Errorf("got states %v, want %v", result.States(), states)
+	}
+	if result.Chat.State.Name != "NAME" {
+		t.Errorf("got final state %v, want NAME", result.Chat.State.Name)
+	}
+	if result.Chat.Params.Get("name") != "Dave" {
+		t.Errorf("got name param %q, want Dave", result.Chat.Params.Get("name"))
+	}
+	if names := namesOf(result.Chat); !reflect.DeepEqual(names, []string{"Dave"}) {
+		t.Errorf("got names data %q, want [Dave]", names)
+	}
+	unreached := []dbot.StateName{"CRASH", "UNUSED"}
+	if !reflect.DeepEqual(result.Unreached, unreached) {
+		t.Errorf("got unreached states %v, want %v", result.Unreached, unreached)
+	}
+}
+
+func TestConversationPanic(t *testing.T) {
+	conversation := Conversation{
+		States: testStates,
+		Chat:   dbot.Chat{ChatID: 42, State: dbot.NewState("MAIN")},
+	}
+
+	result, err := conversation.Run(Say("Crash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Panic != "crash" {
+		t.Errorf("got panic %v, want crash", result.Panic)
+	}
+}
+
+func TestConversationInterrupt(t *testing.T) {
+	conversation := Conversation{
+		States: testStates,
+		Chat:   dbot.Chat{ChatID: 42, State: dbot.NewState("MAIN")},
+	}
+
+	result, err := conversation.Run(Interrupt(dbot.NewState("NAME")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := []string{"What's your name?"}
+	if !reflect.DeepEqual(result.Texts(), texts) {
+		t.Errorf("got texts %q, want %q", result.Texts(), texts)
+	}
+}
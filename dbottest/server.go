@@ -0,0 +1,444 @@
+// Package dbottest provides fake Telegram Bot API server
+// for end-to-end testing of depechebot bots.
+package dbottest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// Token is accepted by Server, use it in depechebot.Config.
+	Token = "123456:TEST-TOKEN"
+	// BotID is Telegram ID of the bot served by Server.
+	BotID = 123456
+	// BotUserName is user name of the bot served by Server.
+	BotUserName = "test_bot"
+
+	// DefaultTimeout is used by Expect* helpers.
+	DefaultTimeout = 5 * time.Second
+
+	defaultMembersCount = 2
+)
+
+// Sent is a request made by the bot to the API, except getMe and getUpdates.
+type Sent struct {
+	Method string
+	ChatID int64
+	// Text is message text or photo caption.
+	Text   string
+	Params url.Values
+}
+
+// Server is in-process fake of Telegram Bot API.
+// Use URL as depechebot.Config.APIEndpoint and Token as TelegramToken.
+type Server struct {
+	URL string
+
+	server *httptest.Server
+
+	mu           sync.Mutex
+	updates      []tgbotapi.Update
+	lastUpdateID int
+	lastMsgID    int
+	newUpdate    chan struct{} // closed and replaced when update is added
+	sent         []Sent
+	newSent      chan struct{} // closed and replaced when request is recorded
+	next         int           // index of the first sent not returned by WaitSent
+	membersCount map[int64]int
+	failures     map[string][]failure // by method
+	languages    map[int]string       // language_code by user ID
+}
+
+type failure struct {
+	code        int
+	description string
+}
+
+// NewServer starts and returns a new Server.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		newUpdate:    make(chan struct{}),
+		newSent:      make(chan struct{}),
+		membersCount: make(map[int64]int),
+		failures:     make(map[string][]failure),
+		languages:    make(map[int]string),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.CloseClientConnections()
+	s.server.Close()
+}
+
+// AddUpdate queues update for the bot. UpdateID is set by the server.
+func (s *Server) AddUpdate(update tgbotapi.Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastUpdateID++
+	update.UpdateID = s.lastUpdateID
+	s.updates = append(s.updates, update)
+	close(s.newUpdate)
+	s.newUpdate = make(chan struct{})
+}
+
+// NewUser returns user with given id and first name.
+func NewUser(id int, firstName string) tgbotapi.User {
+	return tgbotapi.User{
+		ID:        id,
+		FirstName: firstName,
+		UserName:  strings.ToLower(firstName),
+	}
+}
+
+// SetLanguageCode sets language_code of user in updates sent from now on,
+// tgbotapi.User doesn't have it.
+func (s *Server) SetLanguageCode(userID int, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.languages[userID] = code
+}
+
+// SendText sends text message from user to the bot in private chat.
+func (s *Server) SendText(from tgbotapi.User, text string) {
+	chat := &tgbotapi.Chat{ID: int64(from.ID), Type: "private", FirstName: from.FirstName}
+	s.AddUpdate(tgbotapi.Update{Message: s.newMessage(chat, &from, text)})
+}
+
+// SendGroupText sends text message from user to the group chat.
+func (s *Server) SendGroupText(chatID int64, from tgbotapi.User, text string) {
+	chat := &tgbotapi.Chat{ID: chatID, Type: "group", Title: "group"}
+	s.AddUpdate(tgbotapi.Update{Message: s.newMessage(chat, &from, text)})
+}
+
+// MigrateGroup upgrades group chat to supergroup as Telegram does,
+// with service messages in both of them.
+func (s *Server) MigrateGroup(chatID, supergroupID int64, from tgbotapi.User) {
+	group := &tgbotapi.Chat{ID: chatID, Type: "group", Title: "group"}
+	message := s.newMessage(group, &from, "")
+	message.MigrateToChatID = supergroupID
+	s.AddUpdate(tgbotapi.Update{Message: message})
+
+	supergroup := &tgbotapi.Chat{ID: supergroupID, Type: "supergroup", Title: "group"}
+	message = s.newMessage(supergroup, &from, "")
+	message.MigrateFromChatID = chatID
+	s.AddUpdate(tgbotapi.Update{Message: message})
+}
+
+// PressButton presses inline keyboard button with callback data
+// under the last message of the chat.
+func (s *Server) PressButton(chatID int64, from tgbotapi.User, data string) {
+	chatType := "group"
+	if chatID == int64(from.ID) {
+		chatType = "private"
+	}
+	chat := &tgbotapi.Chat{ID: chatID, Type: chatType}
+
+	s.mu.Lock()
+	id := strconv.Itoa(s.lastUpdateID + 1)
+	msg := &tgbotapi.Message{MessageID: s.lastMsgID, Chat: chat, Date: int(time.Now().Unix())}
+	s.mu.Unlock()
+
+	s.AddUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      id,
+		From:    &from,
+		Message: msg,
+		Data:    data,
+	}})
+}
+
+// SendInlineQuery sends inline query (@botname query) from user.
+func (s *Server) SendInlineQuery(from tgbotapi.User, query, offset string) {
+	s.mu.Lock()
+	id := strconv.Itoa(s.lastUpdateID + 1)
+	s.mu.Unlock()
+
+	s.AddUpdate(tgbotapi.Update{InlineQuery: &tgbotapi.InlineQuery{
+		ID:     id,
+		From:   &from,
+		Query:  query,
+		Offset: offset,
+	}})
+}
+
+// Fail makes the next request of method fail with Telegram's error,
+// e.g. Fail("sendMessage", 429, "Too Many Requests: retry after 1").
+// Failures are queued, failed requests are not recorded.
+func (s *Server) Fail(method string, code int, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[method] = append(s.failures[method], failure{code, description})
+}
+
+// SetMembersCount sets the result of getChatMembersCount for the chat.
+func (s *Server) SetMembersCount(chatID int64, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.membersCount[chatID] = count
+}
+
+func (s *Server) newMessage(chat *tgbotapi.Chat, from *tgbotapi.User, text string) *tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastMsgID++
+	return &tgbotapi.Message{
+		MessageID: s.lastMsgID,
+		From:      from,
+		Chat:      chat,
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+}
+
+// AllSent returns all requests made by the bot so far.
+func (s *Server) AllSent() []Sent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Sent(nil), s.sent...)
+}
+
+// WaitSent returns the next request made by the bot,
+// waiting for it no longer than timeout.
+func (s *Server) WaitSent(timeout time.Duration) (Sent, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		if s.next < len(s.sent) {
+			sent := s.sent[s.next]
+			s.next++
+			s.mu.Unlock()
+			return sent, nil
+		}
+		newSent := s.newSent
+		s.mu.Unlock()
+
+		select {
+		case <-newSent:
+		case <-deadline:
+			return Sent{}, errors.New("dbottest: no request made by bot in " + timeout.String())
+		}
+	}
+}
+
+// ExpectSent fails the test unless the next request made by the bot
+// is call of method to the chat with given text.
+func (s *Server) ExpectSent(t testing.TB, method string, chatID int64, text string) Sent {
+	t.Helper()
+
+	sent, err := s.WaitSent(DefaultTimeout)
+	if err != nil {
+		t.Fatalf("expected %v to %v with %q: %v", method, chatID, text, err)
+	}
+	if sent.Method != method || sent.ChatID != chatID || sent.Text != text {
+		t.Fatalf("expected %v to %v with %q, got %v to %v with %q",
+			method, chatID, text, sent.Method, sent.ChatID, sent.Text)
+	}
+
+	return sent
+}
+
+// ExpectText fails the test unless the next request made by the bot
+// is text message to the chat.
+func (s *Server) ExpectText(t testing.TB, chatID int64, text string) Sent {
+	t.Helper()
+	return s.ExpectSent(t, "sendMessage", chatID, text)
+}
+
+// ExpectNothing fails the test if bot makes any request during d.
+func (s *Server) ExpectNothing(t testing.TB, d time.Duration) {
+	t.Helper()
+
+	sent, err := s.WaitSent(d)
+	if err == nil {
+		t.Fatalf("expected no requests, got %v to %v with %q", sent.Method, sent.ChatID, sent.Text)
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "bot"+Token {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	method := parts[1]
+
+	s.mu.Lock()
+	failures := s.failures[method]
+	if len(failures) != 0 {
+		s.failures[method] = failures[1:]
+	}
+	s.mu.Unlock()
+	if len(failures) != 0 {
+		writeError(w, failures[0].code, failures[0].description)
+		return
+	}
+
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil && err != http.ErrNotMultipart {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	switch method {
+	case "getMe":
+		writeResult(w, tgbotapi.User{ID: BotID, FirstName: "Test", UserName: BotUserName})
+	case "getUpdates":
+		s.getUpdates(w, r)
+	case "sendMessage", "sendPhoto", "sendDocument", "sendAudio", "sendSticker",
+		"sendVideo", "sendVoice", "sendLocation", "sendVenue", "sendContact":
+		sent := s.record(method, r.Form)
+		writeResult(w, s.sentMessage(sent))
+	case "getChatMembersCount":
+		sent := s.record(method, r.Form)
+		s.mu.Lock()
+		count, ok := s.membersCount[sent.ChatID]
+		s.mu.Unlock()
+		if !ok {
+			count = defaultMembersCount
+		}
+		writeResult(w, count)
+	case "leaveChat", "answerCallbackQuery", "answerInlineQuery", "sendChatAction",
+		"editMessageText", "editMessageCaption", "editMessageReplyMarkup",
+		"kickChatMember", "unbanChatMember", "setWebhook", "deleteWebhook":
+		s.record(method, r.Form)
+		writeResult(w, true)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.Form.Get("offset"))
+	timeout, _ := strconv.Atoi(r.Form.Get("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+
+	for {
+		s.mu.Lock()
+		// updates before offset are confirmed by the bot
+		for len(s.updates) > 0 && s.updates[0].UpdateID < offset {
+			s.updates = s.updates[1:]
+		}
+		updates := s.withLanguageCodes(s.updates)
+		newUpdate := s.newUpdate
+		s.mu.Unlock()
+
+		if len(updates) != 0 || timeout == 0 {
+			writeResult(w, updates)
+			return
+		}
+
+		select {
+		case <-newUpdate:
+		case <-deadline:
+			timeout = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// withLanguageCodes returns JSON of updates with language_code
+// of their senders. s.mu should be locked.
+func (s *Server) withLanguageCodes(updates []tgbotapi.Update) []json.RawMessage {
+	rawUpdates := []json.RawMessage{}
+	for _, update := range updates {
+		data, _ := json.Marshal(update)
+
+		var fields map[string]json.RawMessage
+		json.Unmarshal(data, &fields)
+		for key, field := range fields {
+			var object map[string]json.RawMessage
+			if json.Unmarshal(field, &object) != nil || object["from"] == nil {
+				continue
+			}
+			var from map[string]interface{}
+			json.Unmarshal(object["from"], &from)
+			id, _ := from["id"].(float64)
+			if code := s.languages[int(id)]; code != "" {
+				from["language_code"] = code
+				object["from"], _ = json.Marshal(from)
+				fields[key], _ = json.Marshal(object)
+			}
+		}
+		data, _ = json.Marshal(fields)
+
+		rawUpdates = append(rawUpdates, data)
+	}
+
+	return rawUpdates
+}
+
+func (s *Server) record(method string, form url.Values) Sent {
+	sent := Sent{
+		Method: method,
+		Text:   form.Get("text"),
+		Params: form,
+	}
+	if sent.Text == "" {
+		sent.Text = form.Get("caption")
+	}
+	sent.ChatID, _ = strconv.ParseInt(form.Get("chat_id"), 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, sent)
+	close(s.newSent)
+	s.newSent = make(chan struct{})
+
+	return sent
+}
+
+func (s *Server) sentMessage(sent Sent) tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastMsgID++
+	return tgbotapi.Message{
+		MessageID: s.lastMsgID,
+		From:      &tgbotapi.User{ID: BotID, FirstName: "Test", UserName: BotUserName},
+		Chat:      &tgbotapi.Chat{ID: sent.ChatID},
+		Date:      int(time.Now().Unix()),
+		Text:      sent.Text,
+	}
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: data})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"ok":false,"error_code":%d,"description":%q}`, code, description)
+}
@@ -1,7 +1,10 @@
 package depechebot
 
 import (
+	"context"
 	"log"
+	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
@@ -22,6 +25,37 @@ type ChatSignal struct {
 	Signal
 	ChatID ChatID
 }
+
+// NewMemberSignal returns ChatSignal routed to conversation of user
+// in group chat (see Config.PerUserGroups), group chat gets it
+// if there is no such.
+func NewMemberSignal(signal Signal, chatID ChatID, userID int) ChatSignal {
+	return ChatSignal{memberSignal{signal, userID}, chatID}
+}
+
+// memberSignal is Signal of member's conversation, see NewMemberSignal.
+type memberSignal struct {
+	Signal
+	userID int
+}
+
+// unwrap returns signal and user it's routed to, 0 for the chat itself.
+func (s ChatSignal) unwrap() (Signal, int) {
+	if member, ok := s.Signal.(memberSignal); ok {
+		return member.Signal, member.userID
+	}
+	return s.Signal, 0
+}
+
+// chatSignal returns signal to chat, which could be member's conversation.
+func chatSignal(chat Chat, signal Signal) ChatSignal {
+	if chat.member {
+		return NewMemberSignal(signal, chat.ChatID, chat.UserID)
+	}
+	return ChatSignal{signal, chat.ChatID}
+}
+
+// BroadSignal is sent in memory, see Bot.StartBroadcast for persistent broadcast.
 type BroadSignal struct {
 	Signal
 	List []ChatID
@@ -29,12 +63,37 @@ type BroadSignal struct {
 
 type Config struct {
 	TelegramToken string
+	// APIEndpoint is Bot API server URL, e.g. local server or fake one
+	// for testing (see dbottest package). https://api.telegram.org if empty.
+	APIEndpoint string
 	//AdminLog func()
-	CommonLog           func(tgbotapi.Update)
-	ChatLog             func(Bot, tgbotapi.Update, Chat)
+	CommonLog func(tgbotapi.Update)
+	ChatLog   func(Bot, tgbotapi.Update, Chat)
+	// StateLog is called when chat enters its (possibly the same) state.
+	StateLog            func(Bot, Chat)
 	StatesConfigPrivate map[StateName]StateActions
 	StatesConfigGroup   map[StateName]StateActions
-	Model               Model
+	// SignalStates are states entered only by State signals, e.g. sent
+	// to SendChan, so they are not reported as unreachable by New.
+	SignalStates []StateName
+	// PerUserGroups runs StatesConfigGroup for every member of group chat
+	// separately, with member's own State, Params and Data. Group-wide
+	// chat is still saved, see Bot.UpdateGroupChat.
+	PerUserGroups       bool
+	InlineHandler       InlineHandler
+	ChosenInlineHandler ChosenInlineHandler
+	// ChatMigrated is called when group chat is upgraded to supergroup
+	// and moved to its new ChatID with state, params and members.
+	ChatMigrated func(bot Bot, oldChatID ChatID, chat Chat)
+	// Catalog translates texts of states to chat's language,
+	// DefaultLanguage is language of texts in states.
+	Catalog         Catalog
+	DefaultLanguage LanguageType
+	// RateLimits of sending, Telegram's defaults if zero.
+	RateLimits RateLimits
+	// Webhook switches bot to webhook mode, nil means long polling.
+	Webhook *WebhookConfig
+	Model   Model
 }
 
 type Bot struct {
@@ -44,11 +103,44 @@ type Bot struct {
 
 	chatsChans struct {
 		*sync.RWMutex
-		m map[ChatID]chan Signal
+		m map[chatKey]chan Signal
 	}
+	groups      *sync.Mutex // serializes UpdateGroupChat
+	migrations  *chatMigrations
+	limiter     *limiter
+	broadcasts  *broadcasts
+	catalog     *catalog
+	languages   *languageCodes
 	api         *tgbotapi.BotAPI
 	updatesChan <-chan tgbotapi.Update
-	stopChan    chan<- struct{}
+	webhook     struct {
+		updates *webhookUpdates
+		server  *http.Server
+	}
+	life *lifecycle
+	// offline is set by RunChat, see offline.go
+	offline func(tgbotapi.Chattable)
+}
+
+// chatKey identifies chat goroutine. UserID is set for member's
+// conversation in group chat, see Config.PerUserGroups.
+type chatKey struct {
+	ChatID ChatID
+	UserID int
+}
+
+// lifecycle is shared by all copies of Bot.
+type lifecycle struct {
+	stop      chan struct{} // closed by Stop()
+	stopOnce  sync.Once
+	started   chan struct{} // closed when Run is called
+	startOnce sync.Once
+	shutdown  chan struct{} // closed when Run's context is done
+	done      chan struct{} // closed when Run returns
+
+	handlers    sync.WaitGroup // chats and inline handlers goroutines
+	senders     sync.WaitGroup
+	stopSenders chan struct{}
 }
 
 func New(c Config) (Bot, error) {
@@ -56,11 +148,41 @@ func New(c Config) (Bot, error) {
 
 	bot := Bot{Config: c}
 	bot.chatsChans.RWMutex = &sync.RWMutex{}
-	bot.chatsChans.m = make(map[ChatID]chan Signal)
+	bot.chatsChans.m = make(map[chatKey]chan Signal)
+	bot.groups = &sync.Mutex{}
+	bot.migrations = newChatMigrations()
+	bot.limiter = newLimiter(c.RateLimits)
+	bot.broadcasts = newBroadcasts()
+	bot.catalog = newCatalog(c.Catalog, c.DefaultLanguage)
+	bot.languages = newLanguageCodes()
 	bot.SendChan = make(chan ChatSignal, sendChanBufSize)
 	bot.SendBroadChan = make(chan BroadSignal, sendBroadChanBufSize)
+	if c.Webhook != nil {
+		bot.webhook.updates = newWebhookUpdates()
+	}
+	bot.life = &lifecycle{
+		stop:        make(chan struct{}),
+		shutdown:    make(chan struct{}),
+		done:        make(chan struct{}),
+		started:     make(chan struct{}),
+		stopSenders: make(chan struct{}),
+	}
+
+	err = c.validateStates()
+	if err != nil {
+		return bot, err
+	}
+
+	client := &http.Client{}
+	if c.APIEndpoint != "" {
+		endpoint, err := url.Parse(c.APIEndpoint)
+		if err != nil {
+			return bot, err
+		}
+		client.Transport = endpointTransport{endpoint, nil}
+	}
 
-	bot.api, err = tgbotapi.NewBotAPI(bot.Config.TelegramToken)
+	bot.api, err = tgbotapi.NewBotAPIWithClient(bot.Config.TelegramToken, client)
 	if err != nil {
 		return bot, err
 	}
@@ -68,10 +190,35 @@ func New(c Config) (Bot, error) {
 	return bot, nil
 }
 
-// Run runs bot and blocks
-func (b *Bot) Run() {
+// Run runs bot and blocks until ctx is done or Stop is called.
+// On shutdown it waits for chats to process already received signals,
+// saves chats and sends queued messages. Note that custom StateActions.While
+// should return when its signal channel is closed.
+func (b *Bot) Run(ctx context.Context) {
 	var err error
 
+	first := false
+	b.life.startOnce.Do(func() {
+		first = true
+		close(b.life.started)
+	})
+	if !first {
+		log.Panic("Run of the same Bot is called more than once")
+	}
+	// Wait returns even if Run panics
+	defer close(b.life.done)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.life.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+		close(b.life.shutdown)
+	}()
+
 	log.Printf("Authorized on account %s", b.api.Self.UserName)
 
 	chatIDs, err := b.Config.Model.Init()
@@ -81,79 +228,175 @@ func (b *Bot) Run() {
 	log.Printf("Loaded %v chats\n", len(chatIDs))
 
 	for _, chatID := range chatIDs {
-		b.chatsChans.m[chatID] = make(chan Signal, chatChanBufSize)
+		// group chats IDs are negative, their members conversations
+		// are started on their first updates
+		if b.Config.PerUserGroups && chatID < 0 {
+			continue
+		}
+		b.chatsChans.m[chatKey{ChatID: chatID}] = make(chan Signal, chatChanBufSize)
 	}
 
-	for _, chatID := range chatIDs {
+	for key := range b.chatsChans.m {
 		b.chatsChans.RLock()
-		go b.processChat(chatID, b.chatsChans.m[chatID])
+		b.life.handlers.Add(1)
+		go b.processChat(key, b.chatsChans.m[key])
 		b.chatsChans.RUnlock()
 	}
 
+	b.life.senders.Add(2)
 	go b.processSendChan()
 	go b.processSendBroadChan()
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = telegramTimeout
-	b.updatesChan, b.stopChan, err = GetUpdatesChan(b.api, u)
-	if err != nil {
-		log.Panic(err)
+	if b.Config.Webhook != nil {
+		err = b.startWebhook(ctx)
+		if err != nil {
+			log.Panic(err)
+		}
+	} else {
+		// getUpdates doesn't work while webhook is set
+		_, err = b.api.RemoveWebhook()
+		if err != nil {
+			log.Panic(err)
+		}
+
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = telegramTimeout
+		b.updatesChan, err = getUpdatesChan(ctx, b.api, u, b.languages)
+		if err != nil {
+			log.Panic(err)
+		}
 	}
 
+	// running broadcasts are resumed once bot is connected
+	b.life.senders.Add(1)
+	go b.processBroadcasts()
+
 	b.processUpdatesChan()
-}
 
-// Stop stops bot.
-// For now, one have to wait about 25 sec on the average (= timeoutTelegram/2)
-// to stop bot, since one cannot cancel long polling (see GetUpdates() in tgbotapi_fixes.go)
-func (b Bot) Stop() {
-	// todo: one should terminate chat's goroutines as well
 	log.Printf("Stopping %s...", b.api.Self.UserName)
-	b.stopChan <- struct{}{}
-	close(b.SendBroadChan)
-	close(b.SendChan)
+
+	// updates are not received anymore, so chats could be safely closed
+	b.closeChats()
+	b.life.handlers.Wait()
+
+	close(b.life.stopSenders)
+	b.life.senders.Wait()
+
+	if flusher, ok := b.Config.Model.(Flusher); ok {
+		err = flusher.Flush()
+		if err != nil {
+			log.Printf("Failed to flush model: error \"%v\"\n", err)
+		}
+	}
+
 	log.Printf("Stopped %s", b.api.Self.UserName)
 }
 
+// Stop makes Run stop and returns at once, so it could be called
+// from handlers too. Use Wait to wait for Run to return.
+// If Run is not called yet, it stops right after start.
+func (b Bot) Stop() {
+	b.life.stopOnce.Do(func() {
+		close(b.life.stop)
+	})
+}
+
+// Wait waits for Run to return, it returns at once if Run is not called.
+// It shouldn't be called from handlers, as Run waits for them.
+func (b Bot) Wait() {
+	select {
+	case <-b.life.started:
+		<-b.life.done
+	default:
+	}
+}
+
+// closeChats closes chats channels. Chats goroutines process
+// buffered signals, save chats and exit.
+func (b Bot) closeChats() {
+	b.chatsChans.Lock()
+	for key, chatChan := range b.chatsChans.m {
+		close(chatChan)
+		delete(b.chatsChans.m, key)
+	}
+	b.chatsChans.Unlock()
+}
+
 func (b Bot) processUpdatesChan() {
 
 	for update := range b.updatesChan {
 
-		b.Config.CommonLog(update)
+		if b.Config.CommonLog != nil {
+			b.Config.CommonLog(update)
+		}
 
-		// todo: update.Query and so on...
-		if update.Message == nil {
+		if update.InlineQuery != nil {
+			b.life.handlers.Add(1)
+			go b.processInlineQuery(*update.InlineQuery)
+			continue
+		}
+		if update.ChosenInlineResult != nil {
+			b.life.handlers.Add(1)
+			go b.processChosenInlineResult(*update.ChosenInlineResult)
+			continue
+		}
+
+		tgChat, from := updateSource(update)
+		if tgChat == nil {
+			if update.CallbackQuery != nil {
+				// answered aside, waiting for rate limits doesn't hold other updates
+				b.life.handlers.Add(1)
+				go b.processInlineCallbackQuery(*update.CallbackQuery)
+			}
 			continue
 		}
 
-		chatID := ChatID(update.Message.Chat.ID)
+		if update.Message != nil &&
+			(update.Message.MigrateToChatID != 0 || update.Message.MigrateFromChatID != 0) {
+			// both old group and new supergroup get service message,
+			// chat is migrated on the first of them
+			b.migrateChat(update.Message)
+			continue
+		}
+
+		chatID := ChatID(tgChat.ID)
+		key := chatKey{ChatID: chatID}
+		if b.Config.PerUserGroups && tgChat.Type != "private" && from != nil {
+			key.UserID = from.ID
+		}
 		b.chatsChans.RLock()
-		chatChan, ok := b.chatsChans.m[chatID]
+		chatChan, ok := b.chatsChans.m[key]
 		b.chatsChans.RUnlock()
 
-		if !ok {
+		if !ok && key.UserID != 0 {
+			b.openMemberChat(tgChat, from)
+		} else if !ok {
 			chat := &Chat{
 				ChatID:    chatID,
 				Abandoned: false,
-				Type:      update.Message.Chat.Type,
-				UserID:    update.Message.From.ID,
-				UserName:  update.Message.From.UserName,
-				FirstName: update.Message.From.FirstName,
-				LastName:  update.Message.From.LastName,
+				Type:      tgChat.Type,
+				UserID:    from.ID,
+				UserName:  from.UserName,
+				FirstName: from.FirstName,
+				LastName:  from.LastName,
 				OpenTime:  time.Now(),
 				LastTime:  time.Now(),
 				State:     StartState,
 				Params:    Params{},
+				Data:      Data{},
 			}
 			err := b.Config.Model.Insert(chat)
 			if err != nil {
 				log.Panic(err)
 			}
+		}
+		if !ok {
 			chatChan = make(chan Signal, chatChanBufSize)
 			b.chatsChans.Lock()
-			b.chatsChans.m[chatID] = chatChan
+			b.chatsChans.m[key] = chatChan
 			b.chatsChans.Unlock()
-			go b.processChat(chatID, chatChan)
+			b.life.handlers.Add(1)
+			go b.processChat(key, chatChan)
 		}
 
 		select {
@@ -165,20 +408,67 @@ func (b Bot) processUpdatesChan() {
 	}
 }
 
+// updateSource returns the chat and the user the update came from.
+// Chat is nil for updates that cannot be routed to any chat.
+func updateSource(update tgbotapi.Update) (*tgbotapi.Chat, *tgbotapi.User) {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat, update.Message.From
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat, update.CallbackQuery.From
+	}
+	return nil, nil
+}
+
+// answerCallbackQuery answers query from the chat, it's counted
+// in the chat's rate limits as other requests.
+func (b Bot) answerCallbackQuery(query *tgbotapi.CallbackQuery, chatID ChatID) {
+	if b.offline != nil {
+		return
+	}
+
+	b.limiter.wait(chatID)
+	_, err := b.api.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
+	if err != nil {
+		log.Printf("Failed to answer callback query %v: error \"%v\"\n", query.ID, err)
+	}
+}
+
 func (b Bot) updateChat(update tgbotapi.Update, chat *Chat) {
 
+	if update.CallbackQuery != nil {
+		b.answerCallbackQuery(update.CallbackQuery, chat.ChatID)
+
+		from := update.CallbackQuery.From
+		b.saveMember(chat.ChatID, from)
+		chat.UserName = from.UserName
+		chat.FirstName = from.FirstName
+		chat.LastName = from.LastName
+		chat.LastTime = time.Now()
+		return
+	}
+
+	b.saveMember(chat.ChatID, update.Message.From)
+	if update.Message.NewChatMember != nil &&
+		update.Message.NewChatMember.ID != b.api.Self.ID {
+		b.saveMember(chat.ChatID, update.Message.NewChatMember)
+	}
+
 	var abandoned = false
 	// checked either bot is kicked itself or he is alone now
 	if update.Message.LeftChatMember != nil {
+		b.removeMember(chat.ChatID, update.Message.LeftChatMember)
 		if update.Message.LeftChatMember.ID == b.api.Self.ID {
 			abandoned = true
-		} else {
+		} else if b.offline == nil {
+			b.limiter.wait(chat.ChatID)
 			count, err := b.api.GetChatMembersCount(update.Message.Chat.ChatConfig())
 			if err != nil {
 				log.Panic(err)
 			}
 			if count == 1 {
 				abandoned = true
+				b.limiter.wait(chat.ChatID)
 				b.api.LeaveChat(update.Message.Chat.ChatConfig())
 			}
 		}
@@ -187,13 +477,6 @@ func (b Bot) updateChat(update tgbotapi.Update, chat *Chat) {
 		update.Message.NewChatMember.ID == b.api.Self.ID {
 		abandoned = false
 	}
-	if update.Message.MigrateToChatID != 0 {
-		// todo: need to do more here to migrate
-		abandoned = true
-	}
-	if update.Message.MigrateFromChatID != 0 {
-		// todo: need to do more here to migrate
-	}
 
 	chat.Abandoned = abandoned
 	chat.UserName = update.Message.From.UserName
@@ -207,14 +490,88 @@ func (b Bot) updateChat(update tgbotapi.Update, chat *Chat) {
 	}
 }
 
+// saveMember saves user's profile and membership in chat.
+func (b Bot) saveMember(chatID ChatID, from *tgbotapi.User) {
+	if b.Config.Model == nil || from == nil {
+		return
+	}
+
+	now := time.Now()
+	err := b.Config.Model.SaveUser(&User{
+		UserID:    from.ID,
+		UserName:  from.UserName,
+		FirstName: from.FirstName,
+		LastName:  from.LastName,
+		OpenTime:  now,
+		LastTime:  now,
+	})
+	if err == nil {
+		err = b.Config.Model.AddMember(chatID, from.ID, now)
+	}
+	if err != nil {
+		log.Printf("Failed to save user %v of chat %v: error \"%v\"\n", from.ID, chatID, err)
+		return
+	}
+
+	if lang, ok := b.languages.takeChanged(from.ID); ok {
+		b.saveUserLanguage(from.ID, lang)
+	}
+}
+
+// saveUserLanguage saves language_code to user's Params, keeping the others.
+func (b Bot) saveUserLanguage(userID int, lang LanguageType) {
+	user, err := b.Config.Model.UserByUserID(userID)
+	if err == nil {
+		if user.Params == nil {
+			user.Params = Params{}
+		}
+		user.Params.Set(TelegramLanguageParam, string(lang))
+		err = b.Config.Model.SaveUser(user)
+	}
+	if err != nil {
+		log.Printf("Failed to save language of user %v: error \"%v\"\n", userID, err)
+	}
+}
+
+func (b Bot) removeMember(chatID ChatID, user *tgbotapi.User) {
+	if b.Config.Model == nil {
+		return
+	}
+
+	err := b.Config.Model.RemoveMember(chatID, user.ID)
+	if err != nil {
+		log.Printf("Failed to remove user %v from chat %v: error \"%v\"\n", user.ID, chatID, err)
+	}
+}
+
 // goroutine
-func (b Bot) processChat(chatID ChatID, signalChan <-chan Signal) {
+func (b Bot) processChat(key chatKey, signalChan <-chan Signal) {
+	defer b.life.handlers.Done()
+
+	var chat *Chat
+	var err error
+	if key.UserID == 0 {
+		chat, err = b.Config.Model.ChatByChatID(key.ChatID)
+	} else {
+		chat, err = b.Config.Model.MemberChat(key.ChatID, key.UserID)
+	}
+	if err != nil {
+		log.Panicf("Error: %v, chatID: %v, userID: %v", err, key.ChatID, key.UserID)
+	}
+	chat.member = key.UserID != 0
+
+	b.runChat(chat, signalChan)
+}
+
+// runChat runs chat's state machine until signalChan is closed.
+func (b Bot) runChat(chat *Chat, signalChan <-chan Signal) {
+	var err error
 	var update tgbotapi.Update
 	var statesConfig map[StateName]StateActions
 
-	chat, err := b.Config.Model.ChatByChatID(chatID)
-	if err != nil {
-		log.Panicf("Error: %v, chatID: %v", err, chatID)
+	// handlers change data through their Chat copy, so map should exist
+	if chat.Data == nil {
+		chat.Data = Data{}
 	}
 
 	if chat.Type == "private" {
@@ -223,83 +580,62 @@ func (b Bot) processChat(chatID ChatID, signalChan <-chan Signal) {
 		statesConfig = b.Config.StatesConfigGroup
 	}
 
+	// states without While entered since the last signal
+	passed := map[StateName]bool{}
+
 	for {
 
 		if _, ok := statesConfig[chat.State.Name]; !ok {
 			log.Panicf("No such state: %v", chat.State.Name)
 		}
 
+		// chat could be migrated while it was processed
+		b.migrations.apply(chat)
+
 		while := statesConfig[chat.State.Name].While
-		after := statesConfig[chat.State.Name].After
-		if while != nil {
+		after := statesConfig[chat.State.Name].after()
+		if while == nil && passed[chat.State.Name] {
+			// states without While lead back here without waiting for signals,
+			// so chat would spin and never see signalChan closed
+			log.Printf("State %v without While is entered again, waiting for update\n", chat.State.Name)
+			while = StateWhile()
+		}
+		if while == nil {
+			passed[chat.State.Name] = true
+		} else {
+			passed = map[StateName]bool{}
 		WhileLoop:
 			for {
 				signal := while(b, signalChan)
+				b.migrations.apply(chat)
 
 				switch signal := signal.(type) {
+				case nil:
+					// signalChan is closed, bot is stopping
+					err = b.saveChat(chat)
+					if err != nil {
+						log.Printf("Failed to save chat %v: error \"%v\"\n", chat.ChatID, err)
+					}
+					return
 				case tgbotapi.Update:
 					update = signal
 					b.updateChat(update, chat)
-					b.Config.ChatLog(b, update, Chat(*chat))
+					if chat.member {
+						b.updateGroupFromMember(chat, update)
+					}
+					if b.Config.ChatLog != nil {
+						b.Config.ChatLog(b, update, Chat(*chat))
+					}
 					break WhileLoop
 				case State:
 					chat.State = signal
 					log.Printf("    Interrupted with state: %v", chat.State)
 					goto BeforeLabel
-				case tgbotapi.MessageConfig:
-					msg := signal
-					msg.ChatID = int64(chat.ChatID)
-					_, err := b.api.Send(msg)
-					if err != nil {
-						log.Printf("Failed to send (%v): error \"%v\"\n", marshal(msg), err)
-						if err.Error() == "forbidden" {
-							chat.Abandoned = true
-						}
-					}
-					continue WhileLoop
-				case tgbotapi.PhotoConfig:
-					msg := signal
-					msg.ChatID = int64(chat.ChatID)
-					_, err := b.api.Send(msg)
-					if err != nil {
-						log.Printf("Failed to send (%v): error \"%v\"\n", marshal(msg), err)
-						if err.Error() == "forbidden" {
-							chat.Abandoned = true
-						}
-					}
+				case chatAbandoned:
+					chat.Abandoned = true
 					continue WhileLoop
-				case tgbotapi.DocumentConfig:
-					msg := signal
-					msg.ChatID = int64(chat.ChatID)
-					_, err := b.api.Send(msg)
-					if err != nil {
-						log.Printf("Failed to send (%v): error \"%v\"\n", marshal(msg), err)
-						if err.Error() == "forbidden" {
-							chat.Abandoned = true
-						}
-					}
-					continue WhileLoop
-				case tgbotapi.AudioConfig:
-					msg := signal
-					msg.ChatID = int64(chat.ChatID)
-					_, err := b.api.Send(msg)
-					if err != nil {
-						log.Printf("Failed to send (%v): error \"%v\"\n", marshal(msg), err)
-						if err.Error() == "forbidden" {
-							chat.Abandoned = true
-						}
-					}
-					continue WhileLoop
-				case tgbotapi.Chattable: // todo: leave only one of Message/PhotoConfig and Chattable
-					msg := signal
-					// fix ChatID in this message!!
-					_, err := b.api.Send(msg)
-					if err != nil {
-						log.Printf("Failed to send (%v): error \"%v\"\n", marshal(msg), err)
-						if err.Error() == "forbidden" {
-							chat.Abandoned = true
-						}
-					}
+				case tgbotapi.Chattable:
+					b.sendToChat(signal, chat)
 					continue WhileLoop
 				default:
 					log.Panicf("Should be either Update, State, Message/PhotoConfig or Chattable")
@@ -310,20 +646,27 @@ func (b Bot) processChat(chatID ChatID, signalChan <-chan Signal) {
 		// todo: consider chat.Abandoned from here?
 		if after != nil {
 			after(b, Chat(*chat), update, &chat.State, &chat.Params)
+			b.flushOffline()
 
 			log.Printf("    State after: %v", chat.State)
 		}
 
 	BeforeLabel:
+		if b.Config.StateLog != nil {
+			b.Config.StateLog(b, Chat(*chat))
+		}
 		if !chat.State.skipBefore {
 			before := statesConfig[chat.State.Name].Before
 			if before != nil {
 				before(b, Chat(*chat))
+				b.flushOffline()
 			}
 		}
 
-		err = b.Config.Model.Update(chat)
-		if err != nil {
+		err = b.saveChat(chat)
+		if err == ErrNoChat {
+			log.Printf("Failed to save chat %v: error \"%v\"\n", chat.ChatID, err)
+		} else if err != nil {
 			log.Panic(err)
 		}
 	}
@@ -331,38 +674,101 @@ func (b Bot) processChat(chatID ChatID, signalChan <-chan Signal) {
 
 // goroutine
 func (b Bot) processSendChan() {
-	const (
-		commonDelay = time.Second / 30
-	)
+	defer b.life.senders.Done()
 
-	for chatSignal := range b.SendChan {
-		b.sendSignal(chatSignal.ChatID, chatSignal.Signal)
-		time.Sleep(commonDelay)
+	for {
+		select {
+		case chatSignal := <-b.SendChan:
+			signal, userID := chatSignal.unwrap()
+			b.sendSignal(chatSignal.ChatID, userID, signal)
+		case <-b.life.stopSenders:
+			for {
+				select {
+				case chatSignal := <-b.SendChan:
+					signal, userID := chatSignal.unwrap()
+					b.sendSignal(chatSignal.ChatID, userID, signal)
+				default:
+					return
+				}
+			}
+		}
 	}
 }
 
 // goroutine
 func (b Bot) processSendBroadChan() {
-	const (
-		commonDelay = time.Second / 30
-	)
+	defer b.life.senders.Done()
 
-	for broadSignal := range b.SendBroadChan {
+	send := func(broadSignal BroadSignal) {
 		for _, chatID := range broadSignal.List {
-			b.sendSignal(chatID, broadSignal.Signal)
-			time.Sleep(commonDelay)
+			b.sendSignal(chatID, 0, broadSignal.Signal)
+		}
+	}
+
+	for {
+		select {
+		case broadSignal := <-b.SendBroadChan:
+			send(broadSignal)
+		case <-b.life.stopSenders:
+			for {
+				select {
+				case broadSignal := <-b.SendBroadChan:
+					send(broadSignal)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
-func (b Bot) sendSignal(chatID ChatID, signal Signal) {
+// signalChat sends signal to chat goroutine, unless bot shuts down
+// while its channel is full. b.chatsChans should be read locked,
+// so that closeChats waits for it.
+func (b Bot) signalChat(chatChan chan Signal, signal Signal) bool {
+	select {
+	case chatChan <- signal:
+		return true
+	default:
+	}
+
+	select {
+	case chatChan <- signal:
+		return true
+	case <-b.life.shutdown:
+		return false
+	}
+}
+
+// sendSignal passes signal to the member's or chat's goroutine.
+// If there is no such goroutine (e.g. bot is stopping), Chattable
+// signals are sent directly and other signals are dropped.
+func (b Bot) sendSignal(chatID ChatID, userID int, signal Signal) {
 	b.chatsChans.RLock()
 	// if b.chatsChans.m[chatID] == nil {
 	// 	b.chatsChans.m[chatID] = make(chan Signal, chatChanBufSize)
 	// 	go b.processChat(chatID, b.chatsChans.m[chatID])
 	// }
-	if b.chatsChans.m[chatID] != nil {
-		b.chatsChans.m[chatID] <- signal
+	chatChan := b.chatsChans.m[chatKey{chatID, userID}]
+	if chatChan == nil {
+		chatChan = b.chatsChans.m[chatKey{ChatID: chatID}]
 	}
+	sent := chatChan != nil && b.signalChat(chatChan, signal)
 	b.chatsChans.RUnlock()
+	if sent {
+		return
+	}
+
+	msg, ok := signal.(tgbotapi.Chattable)
+	if !ok {
+		log.Printf("Dropped signal %v for chat %v\n", signal, chatID)
+		return
+	}
+	_, err := b.send(msg, chatID)
+	if err != nil {
+		log.Printf("Failed to send (%v): error \"%v\"\n", marshal(msg), err)
+		if apiErr, ok := err.(*APIError); ok && apiErr.Permanent() {
+			b.abandonChat(chatID)
+		}
+	}
 }
@@ -0,0 +1,834 @@
+package depechebot_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	dbot "github.com/depechebot/depechebot"
+	"github.com/depechebot/depechebot/dbottest"
+	"github.com/depechebot/depechebot/model/memory"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestBotConversation(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	model := memory.NewModel()
+
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewState("MAIN")),
+		},
+		"MAIN": {
+			Before: dbot.StateBefore(dbot.NewText("Main menu"),
+				[]dbot.InlineButton{dbot.NewInlineButton("Go", "go")}),
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(
+				dbot.ReqToRes{dbot.NewRequest("Help"): dbot.NewText("Press the button")},
+				dbot.CallbackToRes{dbot.NewRequest("go"): dbot.NewState("DONE")},
+			),
+		},
+		"DONE": {
+			Before: dbot.StateBefore(dbot.NewText("Done"), dbot.NewUnprescribedRequest()),
+			While:  dbot.StateWhile(),
+			After:  dbot.StateAfter(dbot.NewState("MAIN")),
+		},
+	}
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		CommonLog:           func(tgbotapi.Update) {},
+		ChatLog:             func(dbot.Bot, tgbotapi.Update, dbot.Chat) {},
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		Model:               model,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	user := dbottest.NewUser(42, "Dave")
+	server.SendText(user, "/start")
+	server.ExpectSent(t, "setWebhook", 0, "")
+	sent := server.ExpectText(t, 42, "Main menu")
+	if sent.Params.Get("reply_markup") == "" {
+		t.Error("inline keyboard is not sent")
+	}
+
+	server.SendText(user, "Help")
+	server.ExpectText(t, 42, "Press the button")
+	server.ExpectText(t, 42, "Main menu")
+
+	server.PressButton(42, user, "go")
+	server.ExpectSent(t, "answerCallbackQuery", 0, "")
+	server.ExpectText(t, 42, "Done")
+
+	bot.Stop()
+	<-done
+
+	chat, err := model.ChatByChatID(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.State.Name != "DONE" {
+		t.Errorf("chat is saved in state %v, want DONE", chat.State.Name)
+	}
+	if chat.FirstName != "Dave" {
+		t.Errorf("chat is saved with first name %q, want Dave", chat.FirstName)
+	}
+
+	users, err := model.UsersByChatID(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].UserID != 42 || users[0].FirstName != "Dave" {
+		t.Errorf("chat members are %+v, want Dave", users)
+	}
+}
+
+func TestBotStopAfterInlineQuery(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken: dbottest.Token,
+		APIEndpoint:   server.URL,
+		InlineHandler: func(dbot.Bot, tgbotapi.InlineQuery) dbot.InlineAnswer {
+			return dbot.InlineAnswer{}
+		},
+		Model: memory.NewModel(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	server.SendInlineQuery(dbottest.NewUser(42, "Dave"), "query", "")
+	server.ExpectSent(t, "setWebhook", 0, "")
+	server.ExpectSent(t, "answerInlineQuery", 0, "")
+
+	go bot.Stop()
+	select {
+	case <-done:
+	case <-time.After(dbottest.DefaultTimeout):
+		t.Fatal("Run didn't return after Stop")
+	}
+}
+
+func TestBotPerUserGroups(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	model := memory.NewModel()
+
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewState("NAME")),
+		},
+		"NAME": {
+			Before: dbot.StateBefore(dbot.NewText("What's your name?"), dbot.NewUnprescribedRequest()),
+			While:  dbot.StateWhile(),
+			After: dbot.StateAfter(
+				dbot.ResponseFunc(func(bot dbot.Bot, chat dbot.Chat, update tgbotapi.Update, state *dbot.State, params *dbot.Params) {
+					params.Set("name", update.Message.Text)
+					err := bot.UpdateGroupChat(chat.ChatID, func(group *dbot.Chat) {
+						group.Params.Set("last", update.Message.Text)
+					})
+					if err != nil {
+						t.Error(err)
+					}
+				}),
+				dbot.NewText("Thanks"),
+				dbot.NewState("DONE"),
+			),
+		},
+		"DONE": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewText("Again"), dbot.NewState("DONE").SkippedBefore()),
+		},
+	}
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		PerUserGroups:       true,
+		Model:               model,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	alice, bob := dbottest.NewUser(1, "Alice"), dbottest.NewUser(2, "Bob")
+	server.SendGroupText(-100, alice, "/start")
+	server.ExpectSent(t, "setWebhook", 0, "")
+	server.ExpectText(t, -100, "What's your name?")
+	server.SendGroupText(-100, bob, "/start")
+	server.ExpectText(t, -100, "What's your name?")
+	server.SendGroupText(-100, alice, "Alice")
+	server.ExpectText(t, -100, "Thanks")
+	server.SendGroupText(-100, bob, "Bob")
+	server.ExpectText(t, -100, "Thanks")
+
+	// e.g. by failed broadcast, members messages don't change it
+	err = bot.UpdateGroupChat(-100, func(group *dbot.Chat) {
+		group.Abandoned = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SendGroupText(-100, alice, "Hi")
+	server.ExpectText(t, -100, "Again")
+
+	bot.Stop()
+	<-done
+
+	for _, user := range []tgbotapi.User{alice, bob} {
+		chat, err := model.MemberChat(-100, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chat.State.Name != "DONE" || chat.Params.Get("name") != user.FirstName {
+			t.Errorf("member %v chat is saved in state %v with params %v", user.ID, chat.State.Name, chat.Params)
+		}
+	}
+
+	group, err := model.ChatByChatID(-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.State.Name != dbot.StartState.Name || group.Params.Get("last") != "Bob" || !group.Abandoned {
+		t.Errorf("group chat is saved in state %v with params %v, abandoned %v",
+			group.State.Name, group.Params, group.Abandoned)
+	}
+}
+
+func TestBotChatMigration(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	model := memory.NewModel()
+
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewState("COUNT")),
+		},
+		"COUNT": {
+			Before: func(bot dbot.Bot, chat dbot.Chat) {
+				bot.SendChan <- dbot.ChatSignal{Signal: tgbotapi.NewMessage(0, "Count"), ChatID: chat.ChatID}
+			},
+			// drops signals it doesn't know
+			While: func(bot dbot.Bot, signals <-chan dbot.Signal) dbot.Signal {
+				for signal := range signals {
+					switch signal.(type) {
+					case tgbotapi.Update, tgbotapi.Chattable:
+						return signal
+					}
+				}
+				return nil
+			},
+			After: dbot.StateAfter(
+				dbot.ResponseFunc(func(bot dbot.Bot, chat dbot.Chat, update tgbotapi.Update, state *dbot.State, params *dbot.Params) {
+					params.Set("count", params.Get("count")+"+")
+				}),
+				dbot.NewState("COUNT"),
+			),
+		},
+	}
+
+	migrated := make(chan dbot.ChatID, 1)
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		ChatMigrated: func(bot dbot.Bot, oldChatID dbot.ChatID, chat dbot.Chat) {
+			if chat.ChatID != -1001 {
+				t.Errorf("ChatMigrated got chat %v, want -1001", chat.ChatID)
+			}
+			migrated <- oldChatID
+		},
+		Model: model,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	user := dbottest.NewUser(1, "Alice")
+	server.SendGroupText(-1, user, "/start")
+	server.ExpectSent(t, "setWebhook", 0, "")
+	server.ExpectText(t, -1, "Count")
+	server.SendGroupText(-1, user, "one")
+	server.ExpectText(t, -1, "Count")
+
+	server.MigrateGroup(-1, -1001, user)
+	select {
+	case oldChatID := <-migrated:
+		if oldChatID != -1 {
+			t.Errorf("ChatMigrated got old chat %v, want -1", oldChatID)
+		}
+	case <-time.After(dbottest.DefaultTimeout):
+		t.Fatal("ChatMigrated is not called")
+	}
+
+	server.SendGroupText(-1001, user, "two")
+	server.ExpectText(t, -1001, "Count")
+
+	bot.Stop()
+	<-done
+
+	if _, err := model.ChatByChatID(-1); err == nil {
+		t.Error("old chat is saved after migration")
+	}
+	chat, err := model.ChatByChatID(-1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.Params.Get("count") != "++" || chat.Type != "supergroup" {
+		t.Errorf("migrated chat is saved with type %v and params %v", chat.Type, chat.Params)
+	}
+}
+
+func TestBotSendErrors(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	model := memory.NewModel()
+
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewText("Hello"), dbot.NewState("START").SkippedBefore()),
+		},
+	}
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		Model:               model,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	user := dbottest.NewUser(42, "Dave")
+
+	// retried after flood wait
+	server.Fail("sendMessage", 429, "Too Many Requests: retry after 1")
+	start := time.Now()
+	server.SendText(user, "hi")
+	server.ExpectSent(t, "setWebhook", 0, "")
+	server.ExpectText(t, 42, "Hello")
+	if time.Since(start) < time.Second {
+		t.Errorf("flood wait is not honoured, sent after %v", time.Since(start))
+	}
+
+	// not resent on network error, message could be delivered
+	server.Fail("sendMessage", 502, "")
+	server.SendText(user, "hi")
+	server.ExpectNothing(t, 100*time.Millisecond)
+
+	// not retried on bad request
+	server.Fail("sendMessage", 400, "Bad Request: message is too long")
+	server.SendText(user, "hi")
+	server.ExpectNothing(t, 100*time.Millisecond)
+
+	// blocked
+	server.Fail("sendMessage", 403, "Forbidden: bot was blocked by the user")
+	server.SendText(user, "hi")
+	server.ExpectNothing(t, 100*time.Millisecond)
+
+	bot.Stop()
+	<-done
+
+	chat, err := model.ChatByChatID(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chat.Abandoned {
+		t.Error("chat is not abandoned after bot is blocked")
+	}
+}
+
+func TestBotBroadcast(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	model := memory.NewModel()
+
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewState("START").SkippedBefore()),
+		},
+	}
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		Model:               model,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// broadcast left running by previous run
+	now := time.Now()
+	resumed := &dbot.Broadcast{
+		Message:    dbot.BroadcastMessage{Chattable: tgbotapi.NewMessage(0, "Resumed")},
+		Status:     dbot.BroadcastRunning,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	err = model.InsertBroadcast(resumed, []dbot.ChatID{41, 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.UpdateRecipient(resumed.ID, dbot.Recipient{ChatID: 41, Status: dbot.RecipientSent, Time: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	server.ExpectSent(t, "setWebhook", 0, "")
+	server.ExpectText(t, 42, "Resumed")
+	server.SendText(dbottest.NewUser(43, "Bob"), "/start")
+	server.ExpectNothing(t, 100*time.Millisecond)
+
+	server.Fail("sendMessage", 403, "Forbidden: bot was blocked by the user")
+	bc, err := bot.StartBroadcast(tgbotapi.NewMessage(0, "News"), []dbot.ChatID{43, 42, 43})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ExpectText(t, 42, "News")
+
+	var progress dbot.BroadcastProgress
+	for deadline := time.Now().Add(dbottest.DefaultTimeout); time.Now().Before(deadline); {
+		progress, err = bot.BroadcastProgress(bc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if progress.Status == dbot.BroadcastDone {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if progress.Status != dbot.BroadcastDone || progress.Total != 2 ||
+		progress.Sent != 1 || progress.Blocked != 1 || progress.Pending != 0 {
+		t.Errorf("broadcast progress is %+v", progress)
+	}
+
+	report, err := bot.BroadcastReport(bc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 2 || report[0].ChatID != 43 || report[0].Error == "" {
+		t.Errorf("broadcast report is %+v", report)
+	}
+
+	if err := bot.CancelBroadcast(bc.ID); err == nil {
+		t.Error("CancelBroadcast of done broadcast returned no error")
+	}
+
+	bot.Stop()
+	<-done
+
+	chat, err := model.ChatByChatID(43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chat.Abandoned {
+		t.Error("chat is not abandoned after bot is blocked by broadcast")
+	}
+}
+
+func TestBotBroadcastQuery(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	model := memory.NewModel()
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken: dbottest.Token,
+		APIEndpoint:   server.URL,
+		Model:         model,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, chat := range []dbot.Chat{
+		{ChatID: 1, Type: "private", LastTime: now, State: dbot.NewState("MAIN")},
+		{ChatID: 2, Type: "private", LastTime: now.Add(-48 * time.Hour), State: dbot.NewState("MAIN")},
+		{ChatID: 3, Type: "private", LastTime: now.Add(-48 * time.Hour), State: dbot.NewState("MAIN"), Abandoned: true},
+		{ChatID: 4, Type: "private", LastTime: now.Add(-48 * time.Hour), State: dbot.NewState("FORM")},
+		{ChatID: -5, Type: "group", LastTime: now.Add(-48 * time.Hour), State: dbot.NewState("MAIN")},
+	} {
+		chat := chat
+		chat.Params = dbot.Params{}
+		err = model.Insert(&chat)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := dbot.NewQuery().InState("MAIN").OfType("private").WithAbandoned(false).InactiveFor(24 * time.Hour)
+	preview, err := bot.PreviewBroadcast(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Count() != 1 || preview.ChatIDs[0] != 2 {
+		t.Errorf("broadcast preview is %v, want [2]", preview.ChatIDs)
+	}
+
+	bc, err := bot.StartBroadcastQuery(tgbotapi.NewMessage(0, "We miss you"), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := bot.BroadcastReport(bc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0].ChatID != 2 || report[0].Status != dbot.RecipientPending {
+		t.Errorf("broadcast report is %+v", report)
+	}
+}
+
+func TestBotLanguage(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewState("MAIN")),
+		},
+		"MAIN": {
+			Before: dbot.StateBefore(dbot.NewText("Main menu"),
+				[]dbot.Request{dbot.NewRequest("Help"), dbot.NewRequest("English")}),
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(
+				dbot.ReqToRes{
+					dbot.NewRequest("Help"):    dbot.NewText("Press the button"),
+					dbot.NewRequest("English"): dbot.NewParams(dbot.LanguageParam, "en"),
+				},
+				dbot.NewState("MAIN"),
+			),
+		},
+	}
+
+	model := memory.NewModel()
+	config := dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		Catalog: dbot.Catalog{
+			"ru": {"Main menu": "Главное меню", "Help": "Помощь", "Press the button": "Нажмите кнопку"},
+		},
+		DefaultLanguage: "en",
+		Model:           model,
+	}
+	bot, err := dbot.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	ivan, dave := dbottest.NewUser(1, "Ivan"), dbottest.NewUser(2, "Dave")
+	server.SetLanguageCode(ivan.ID, "ru-RU")
+	server.SendText(ivan, "/start")
+	server.ExpectSent(t, "setWebhook", 0, "")
+	sent := server.ExpectText(t, 1, "Главное меню")
+	if markup := sent.Params.Get("reply_markup"); !strings.Contains(markup, "Помощь") || !strings.Contains(markup, "English") {
+		t.Errorf("keyboard is not localized: %v", markup)
+	}
+	server.SendText(dave, "/start")
+	server.ExpectText(t, 2, "Main menu")
+
+	server.SendText(ivan, "Помощь")
+	server.ExpectText(t, 1, "Нажмите кнопку")
+	server.ExpectText(t, 1, "Главное меню")
+	server.SendText(dave, "Help")
+	server.ExpectText(t, 2, "Press the button")
+	server.ExpectText(t, 2, "Main menu")
+
+	// stored preference overrides language_code
+	server.SendText(ivan, "English")
+	server.ExpectText(t, 1, "Main menu")
+
+	olga := dbottest.NewUser(3, "Olga")
+	server.SetLanguageCode(olga.ID, "ru")
+	server.SendText(olga, "/start")
+	server.ExpectText(t, 3, "Главное меню")
+
+	// group is shared by users, its language is the default one
+	server.SendGroupText(-100, ivan, "/start")
+	server.ExpectText(t, -100, "Main menu")
+
+	bot.Stop()
+	<-done
+
+	// language_code is kept after restart, until new updates bring it
+	restarted := dbottest.NewServer()
+	defer restarted.Close()
+	config.APIEndpoint = restarted.URL
+	restartedBot, err := dbot.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = make(chan struct{})
+	go func() {
+		restartedBot.Run(context.Background())
+		close(done)
+	}()
+
+	restarted.SendText(olga, "Помощь")
+	restarted.ExpectSent(t, "setWebhook", 0, "")
+	restarted.ExpectText(t, 3, "Нажмите кнопку")
+	restarted.ExpectText(t, 3, "Главное меню")
+
+	restartedBot.Stop()
+	<-done
+
+	user, err := model.UserByUserID(olga.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang := user.Params.Get(dbot.TelegramLanguageParam); lang != "ru" {
+		t.Errorf("saved language_code is %q", lang)
+	}
+}
+
+func TestValidateStates(t *testing.T) {
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While:     dbot.StateWhile(),
+			Responser: dbot.StateResponser(dbot.NewState("MAIN")),
+		},
+		"MAIN": {
+			While: dbot.StateWhile(),
+			Responser: dbot.StateResponser(
+				dbot.ReqToRes{
+					dbot.NewRequest("Help"):       dbot.NewState("HELP"),
+					dbot.NewRequest("Settings"):   dbot.StateResponser(dbot.NewText("Settings"), dbot.NewState("SETTINGS")),
+					dbot.NewUnprescribedRequest(): dbot.NewState("MAIN"),
+				},
+				dbot.CallbackToRes{dbot.NewRequest("go"): dbot.NewState("GO")},
+			),
+		},
+		"HELP": {
+			While:     dbot.StateWhile(),
+			Responser: dbot.StateResponser(dbot.NewState("MAIN")),
+		},
+		"ADMIN": {
+			While:     dbot.StateWhile(),
+			Responser: dbot.StateResponser(dbot.NewState("MAIN")),
+		},
+		"OLD": {
+			While:     dbot.StateWhile(),
+			Responser: dbot.StateResponser(dbot.NewState("MAIN")),
+		},
+	}
+
+	err := dbot.ValidateStates(states, "ADMIN")
+	want := `invalid states config:
+	state MAIN: transition to undefined state SETTINGS on message "Settings"
+	state MAIN: transition to undefined state GO on callback "go"
+	state OLD is unreachable from START`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error:\n%v\nwant:\n%v", err, want)
+	}
+
+	// After of unreachable state leads nowhere, so OLD is still reported
+	states["OLD"] = dbot.StateActions{
+		While: dbot.StateWhile(),
+		After: dbot.StateAfter(dbot.NewState("MAIN")),
+	}
+	err = dbot.ValidateStates(states, "ADMIN")
+	if err == nil || err.Error() != want {
+		t.Errorf("got error:\n%v\nwant:\n%v", err, want)
+	}
+
+	// both After and Responser
+	err = dbot.ValidateStates(map[dbot.StateName]dbot.StateActions{
+		"START": {
+			After:     dbot.StateAfter(dbot.NewState("START")),
+			Responser: dbot.StateResponser(dbot.NewState("START")),
+		},
+	})
+	want = `invalid states config:
+	state START: both After and Responser are set, Responser is not run`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error:\n%v\nwant:\n%v", err, want)
+	}
+
+	// reachable ResponseFunc could lead anywhere, so OLD is not reported
+	states["HELP"] = dbot.StateActions{
+		While: dbot.StateWhile(),
+		Responser: dbot.ResponseFunc(func(bot dbot.Bot, chat dbot.Chat, update tgbotapi.Update, state *dbot.State, params *dbot.Params) {
+			*state = dbot.NewState("MAIN")
+		}),
+	}
+	delete(states, "START")
+	_, err = dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		SignalStates:        []dbot.StateName{"ADMIN"},
+	})
+	want = `invalid states config:
+	StatesConfigPrivate: missing state START
+	StatesConfigPrivate: state MAIN: transition to undefined state SETTINGS on message "Settings"
+	StatesConfigPrivate: state MAIN: transition to undefined state GO on callback "go"`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error:\n%v\nwant:\n%v", err, want)
+	}
+}
+
+func TestBotStopWithoutRun(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken: dbottest.Token,
+		APIEndpoint:   server.URL,
+		Model:         memory.NewModel(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		bot.Stop()
+		bot.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(dbottest.DefaultTimeout):
+		t.Fatal("Stop didn't return without Run")
+	}
+}
+
+func TestBotStopFromHandler(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: func(bot dbot.Bot, chat dbot.Chat, update tgbotapi.Update, state *dbot.State, params *dbot.Params) {
+				bot.Stop()
+			},
+		},
+	}
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		Model:               memory.NewModel(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+
+	server.SendText(dbottest.NewUser(42, "Dave"), "stop")
+	select {
+	case <-done:
+	case <-time.After(dbottest.DefaultTimeout):
+		t.Fatal("Run didn't return after Stop from handler")
+	}
+	bot.Wait()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic on the second Run")
+		}
+	}()
+	bot.Run(context.Background())
+}
+
+func TestStatesWithoutWhile(t *testing.T) {
+	// START and MAIN lead to each other without waiting for updates
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			Before: dbot.StateBefore(dbot.NewText("Start"), nil),
+			After:  dbot.StateAfter(dbot.NewState("MAIN")),
+		},
+		"MAIN": {
+			Before: dbot.StateBefore(dbot.NewText("Main menu"), nil),
+			After:  dbot.StateAfter(dbot.NewState("START")),
+		},
+	}
+
+	result, err := dbottest.Conversation{States: states}.Run(dbottest.Say("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every state is passed once per signal
+	want := []string{"Main menu", "Start", "Main menu", "Start", "Main menu"}
+	if strings.Join(result.Texts(), ", ") != strings.Join(want, ", ") {
+		t.Errorf("got texts %q, want %q", result.Texts(), want)
+	}
+}
@@ -0,0 +1,102 @@
+package depechebot
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Kinds of Telegram API errors, check them with errors.Is.
+var (
+	// ErrBlocked means bot is blocked by user, kicked from chat
+	// or user is deactivated.
+	ErrBlocked      = errors.New("bot is blocked or kicked")
+	ErrChatNotFound = errors.New("chat not found")
+	// ErrFloodWait means too many requests, see APIError.RetryAfter.
+	ErrFloodWait  = errors.New("flood wait")
+	ErrBadRequest = errors.New("bad request")
+	ErrNetwork    = errors.New("network error")
+)
+
+// APIError is classified error of Telegram API request.
+type APIError struct {
+	// Kind is one of ErrBlocked, ErrChatNotFound, ErrFloodWait,
+	// ErrBadRequest, ErrNetwork or nil if error is unknown.
+	Kind error
+	// RetryAfter is time to wait before next request on ErrFloodWait.
+	RetryAfter time.Duration
+	// Err is original error.
+	Err error
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// Temporary reports whether request could succeed if retried.
+func (e *APIError) Temporary() bool {
+	return e.Kind == ErrFloodWait || e.Kind == ErrNetwork
+}
+
+// unsent reports whether request failed before it was written,
+// e.g. connection was refused or host was not resolved.
+func (e *APIError) unsent() bool {
+	var opErr *net.OpError
+	if errors.As(e.Err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(e.Err, &dnsErr)
+}
+
+// Permanent reports whether chat is not reachable anymore.
+func (e *APIError) Permanent() bool {
+	return e.Kind == ErrBlocked || e.Kind == ErrChatNotFound
+}
+
+// classifyError wraps error returned by tgbotapi into *APIError.
+// tgbotapi keeps Telegram's error description only, so it's parsed.
+func classifyError(err error) *APIError {
+	if err == nil {
+		return nil
+	}
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+
+	apiErr := &APIError{Err: err}
+	description := err.Error()
+
+	var netErr net.Error
+	switch {
+	case description == tgbotapi.ErrAPIForbidden || strings.HasPrefix(description, "Forbidden:"):
+		apiErr.Kind = ErrBlocked
+	case strings.HasPrefix(description, "Too Many Requests"):
+		// "Too Many Requests: retry after 35"
+		apiErr.Kind = ErrFloodWait
+		fields := strings.Fields(description)
+		seconds, _ := strconv.Atoi(fields[len(fields)-1])
+		if seconds <= 0 {
+			seconds = 1
+		}
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	case strings.HasPrefix(description, "Bad Request: chat not found"):
+		apiErr.Kind = ErrChatNotFound
+	case strings.HasPrefix(description, "Bad Request:"):
+		apiErr.Kind = ErrBadRequest
+	case errors.As(err, &netErr), description == "",
+		strings.Contains(description, "Internal Server Error"), strings.Contains(description, "Bad Gateway"):
+		// empty description means response wasn't API's JSON, e.g. from proxy
+		apiErr.Kind = ErrNetwork
+	}
+
+	return apiErr
+}
@@ -0,0 +1,66 @@
+package depechebot
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err        error
+		kind       error
+		retryAfter time.Duration
+		temporary  bool
+		permanent  bool
+	}{
+		{errors.New("forbidden"), ErrBlocked, 0, false, true},
+		{errors.New("Forbidden: bot was blocked by the user"), ErrBlocked, 0, false, true},
+		{errors.New("Bad Request: chat not found"), ErrChatNotFound, 0, false, true},
+		{errors.New("Bad Request: message text is empty"), ErrBadRequest, 0, false, false},
+		{errors.New("Too Many Requests: retry after 35"), ErrFloodWait, 35 * time.Second, true, false},
+		{errors.New("Too Many Requests: retry after"), ErrFloodWait, time.Second, true, false},
+		{&url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}},
+			ErrNetwork, 0, true, false},
+		{errors.New(""), ErrNetwork, 0, true, false},
+		{errors.New("Unauthorized"), nil, 0, false, false},
+	}
+
+	for _, test := range tests {
+		err := classifyError(test.err)
+		if err.Kind != test.kind || err.RetryAfter != test.retryAfter ||
+			err.Temporary() != test.temporary || err.Permanent() != test.permanent {
+			t.Errorf("classifyError(%q) = %+v", test.err, err)
+		}
+		if test.kind != nil && !errors.Is(err, test.kind) {
+			t.Errorf("classifyError(%q) is not %v", test.err, test.kind)
+		}
+		if err.Error() != test.err.Error() {
+			t.Errorf("classifyError(%q) has message %q", test.err, err.Error())
+		}
+	}
+
+	if classifyError(nil) != nil {
+		t.Error("classifyError(nil) is not nil")
+	}
+}
+
+func TestAPIErrorUnsent(t *testing.T) {
+	tests := []struct {
+		err    error
+		unsent bool
+	}{
+		{&url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}, true},
+		{&url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.DNSError{Err: "no such host"}}, true},
+		{&url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.OpError{Op: "read", Err: errors.New("reset")}}, false},
+		{errors.New(""), false},
+	}
+
+	for _, test := range tests {
+		if unsent := classifyError(test.err).unsent(); unsent != test.unsent {
+			t.Errorf("classifyError(%q).unsent() = %v", test.err, unsent)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package depechebot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GraphFormat is output format of WriteStatesGraph.
+type GraphFormat string
+
+const (
+	GraphDOT     GraphFormat = "dot" // Graphviz
+	GraphMermaid GraphFormat = "mermaid"
+)
+
+// graphEdge is transitions between two states merged into one edge.
+type graphEdge struct {
+	from, to StateName
+	labels   []string
+	callback bool // all transitions are by inline buttons
+}
+
+// WriteStatesGraph writes states as directed graph, e.g. for
+// "dot -Tsvg": states are nodes, transitions of their Responsers
+// are edges labeled with button (message) texts, "*" for other texts.
+// Callback transitions are dashed. States changed by After, ResponseFunc
+// or custom responsers are dashed too, as their transitions are not known,
+// and undefined states are red.
+func WriteStatesGraph(w io.Writer, states map[StateName]StateActions, format GraphFormat) error {
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	nodes := []StateName{}
+	seen := map[StateName]bool{}
+	addNode := func(name StateName) {
+		if !seen[name] {
+			seen[name] = true
+			nodes = append(nodes, name)
+		}
+	}
+	if _, ok := states[StartState.Name]; ok {
+		addNode(StartState.Name)
+	}
+
+	edges := []*graphEdge{}
+	dynamic := map[StateName]bool{}
+	for _, name := range names {
+		from := StateName(name)
+		addNode(from)
+
+		ts, dyn := transitions(from, states[from])
+		dynamic[from] = dyn
+		byTarget := map[StateName]*graphEdge{}
+		for _, t := range ts {
+			addNode(t.to)
+			edge := byTarget[t.to]
+			if edge == nil {
+				edge = &graphEdge{from: from, to: t.to, callback: true}
+				byTarget[t.to] = edge
+				edges = append(edges, edge)
+			}
+			edge.callback = edge.callback && t.kind == transitionCallback
+			if label := t.label(); label != "" && !containsLabel(edge.labels, label) {
+				edge.labels = append(edge.labels, label)
+			}
+		}
+	}
+
+	undefined := func(name StateName) bool {
+		_, ok := states[name]
+		return !ok
+	}
+
+	switch format {
+	case GraphDOT:
+		return writeDOT(w, nodes, edges, dynamic, undefined)
+	case GraphMermaid:
+		return writeMermaid(w, nodes, edges, dynamic, undefined)
+	default:
+		return fmt.Errorf("unknown graph format %q", format)
+	}
+}
+
+// label returns edge label of t, empty for transition on any update.
+func (t transition) label() string {
+	switch {
+	case t.kind == transitionAny:
+		return ""
+	case t.request.unprescribed:
+		return "*"
+	default:
+		return t.request.Text
+	}
+}
+
+func writeDOT(w io.Writer, nodes []StateName, edges []*graphEdge,
+	dynamic map[StateName]bool, undefined func(StateName) bool) error {
+
+	lines := []string{"digraph states {", "\tnode [shape=box];"}
+	for _, name := range nodes {
+		attrs := []string{}
+		if name == StartState.Name {
+			attrs = append(attrs, "peripheries=2")
+		}
+		if dynamic[name] {
+			attrs = append(attrs, "style=dashed")
+		}
+		if undefined(name) {
+			attrs = append(attrs, "color=red")
+		}
+		line := "\t" + dotQuote(string(name))
+		if len(attrs) != 0 {
+			line += " [" + strings.Join(attrs, ", ") + "]"
+		}
+		lines = append(lines, line+";")
+	}
+
+	for _, edge := range edges {
+		attrs := []string{}
+		if len(edge.labels) != 0 {
+			attrs = append(attrs, "label="+dotQuote(strings.Join(edge.labels, "\n")))
+		}
+		if edge.callback {
+			attrs = append(attrs, "style=dashed")
+		}
+		line := "\t" + dotQuote(string(edge.from)) + " -> " + dotQuote(string(edge.to))
+		if len(attrs) != 0 {
+			line += " [" + strings.Join(attrs, ", ") + "]"
+		}
+		lines = append(lines, line+";")
+	}
+	lines = append(lines, "}")
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+func writeMermaid(w io.Writer, nodes []StateName, edges []*graphEdge,
+	dynamic map[StateName]bool, undefined func(StateName) bool) error {
+
+	// state names could be any strings, so nodes have generated ids
+	ids := map[StateName]string{}
+	lines := []string{"flowchart TD"}
+	for i, name := range nodes {
+		ids[name] = fmt.Sprintf("s%v", i)
+		shape := `["%v"]`
+		if name == StartState.Name {
+			shape = `(["%v"])`
+		}
+		lines = append(lines, "\t"+ids[name]+fmt.Sprintf(shape, mermaidEscape(string(name))))
+	}
+
+	for _, edge := range edges {
+		arrow := "-->"
+		if edge.callback {
+			arrow = "-.->"
+		}
+		if len(edge.labels) != 0 {
+			labels := []string{}
+			for _, label := range edge.labels {
+				labels = append(labels, mermaidEscape(label))
+			}
+			arrow += `|"` + strings.Join(labels, "<br>") + `"|`
+		}
+		lines = append(lines, "\t"+ids[edge.from]+" "+arrow+" "+ids[edge.to])
+	}
+
+	classes := []struct {
+		name, style string
+		has         func(StateName) bool
+	}{
+		{"dynamic", "stroke-dasharray: 5 5", func(name StateName) bool { return dynamic[name] }},
+		{"undefined", "stroke: red", undefined},
+	}
+	for _, class := range classes {
+		classIDs := []string{}
+		for _, name := range nodes {
+			if class.has(name) {
+				classIDs = append(classIDs, ids[name])
+			}
+		}
+		if len(classIDs) != 0 {
+			lines = append(lines, "\tclassDef "+class.name+" "+class.style,
+				"\tclass "+strings.Join(classIDs, ",")+" "+class.name)
+		}
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", " ")
+
+func mermaidEscape(s string) string {
+	return mermaidEscaper.Replace(s)
+}
+
+func containsLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package depechebot
+
+import (
+	"bytes"
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestWriteStatesGraph(t *testing.T) {
+	states := map[StateName]StateActions{
+		"START": {Responser: StateResponser(NewState("MAIN"))},
+		"MAIN": {
+			Responser: StateResponser(
+				ReqToRes{
+					NewRequest("Name"):       NewState("NAME"),
+					NewRequest(`Say "hi"`):   StateResponser(NewText("Hi"), NewState("MAIN")),
+					NewUnprescribedRequest(): NewState("MAIN"),
+				},
+				CallbackToRes{NewRequest("go"): NewState("GONE")},
+			),
+		},
+		"NAME": {
+			Responser: StateResponser(
+				ResponseFunc(func(Bot, Chat, tgbotapi.Update, *State, *Params) {}),
+				NewState("MAIN"),
+			),
+		},
+	}
+
+	tests := []struct {
+		format GraphFormat
+		want   string
+	}{
+		{GraphDOT, `digraph states {
+	node [shape=box];
+	"START" [peripheries=2];
+	"MAIN";
+	"NAME" [style=dashed];
+	"GONE" [color=red];
+	"MAIN" -> "NAME" [label="Name"];
+	"MAIN" -> "MAIN" [label="Say \"hi\"\n*"];
+	"MAIN" -> "GONE" [label="go", style=dashed];
+	"NAME" -> "MAIN";
+	"START" -> "MAIN";
+}
+`},
+		{GraphMermaid, `flowchart TD
+	s0(["START"])
+	s1["MAIN"]
+	s2["NAME"]
+	s3["GONE"]
+	s1 -->|"Name"| s2
+	s1 -->|"Say #quot;hi#quot;<br>*"| s1
+	s1 -.->|"go"| s3
+	s2 --> s1
+	s0 --> s1
+	classDef dynamic stroke-dasharray: 5 5
+	class s2 dynamic
+	classDef undefined stroke: red
+	class s3 undefined
+`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := WriteStatesGraph(&buf, states, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%v graph is:\n%v\nwant:\n%v", test.format, buf.String(), test.want)
+		}
+	}
+}
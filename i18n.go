@@ -0,0 +1,260 @@
+package depechebot
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LanguageType is IETF language tag as Telegram's language_code, e.g. "en" or "pt-br".
+type LanguageType string
+
+// LanguageParam is chat's Params key of stored language preference,
+// it overrides Telegram's language_code, e.g. Params{LanguageParam: "ru"}.
+const LanguageParam = "language"
+
+// TelegramLanguageParam is user's Params key of the last Telegram's
+// language_code, so that chats keep their language after restart.
+const TelegramLanguageParam = "telegram_language"
+
+// Catalog is message catalog: texts of StatesConfig in Config.DefaultLanguage
+// translated to other languages, e.g.
+//
+//	Catalog{"ru": {"Help": "Помощь", "Main menu": "Главное меню"}}
+//
+// Requests, Texts and Photos captions are localized for chat's language
+// (see Bot.Language), ReqToRes matches localized button labels to the same
+// requests. Texts without translation are sent as is. Regional language
+// like "pt-br" falls back to its base "pt".
+type Catalog map[LanguageType]map[string]string
+
+// catalog is Catalog with reverse index of translations.
+type catalog struct {
+	Catalog
+	defaultLanguage LanguageType
+	// requests maps translations to original texts, by language
+	// and for all languages ("")
+	requests map[LanguageType]map[string]string
+}
+
+func newCatalog(c Catalog, defaultLanguage LanguageType) *catalog {
+	cat := &catalog{
+		Catalog:         c,
+		defaultLanguage: defaultLanguage,
+		requests:        map[LanguageType]map[string]string{"": {}},
+	}
+
+	// sorted, so that labels clashing between languages are resolved the same way
+	languages := make([]string, 0, len(c))
+	for lang := range c {
+		languages = append(languages, string(lang))
+	}
+	sort.Strings(languages)
+
+	for _, lang := range languages {
+		requests := map[string]string{}
+		for text, translation := range c[LanguageType(lang)] {
+			requests[translation] = text
+			if _, ok := cat.requests[""][translation]; !ok {
+				cat.requests[""][translation] = text
+			}
+		}
+		cat.requests[LanguageType(lang)] = requests
+	}
+
+	return cat
+}
+
+// localize returns text translated to lang, or text itself.
+func (c *catalog) localize(lang LanguageType, text string) string {
+	if c == nil || text == "" {
+		return text
+	}
+	for _, l := range fallbacks(lang) {
+		if translation, ok := c.Catalog[l][text]; ok {
+			return translation
+		}
+	}
+	return text
+}
+
+// request returns original text of localized request label,
+// preferring lang's translations.
+func (c *catalog) request(lang LanguageType, label string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	for _, l := range append(fallbacks(lang), "") {
+		if text, ok := c.requests[l][label]; ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// fallbacks returns lang and its base language, e.g. "pt-br" and "pt".
+func fallbacks(lang LanguageType) []LanguageType {
+	lang = LanguageType(strings.ToLower(string(lang)))
+	if i := strings.IndexAny(string(lang), "-_"); i > 0 {
+		return []LanguageType{lang, lang[:i]}
+	}
+	return []LanguageType{lang}
+}
+
+// Language returns chat's language: stored preference (see LanguageParam),
+// then Telegram's language_code of chat.UserID, then Config.DefaultLanguage.
+// Group chats are shared by users, so language_code is used only
+// for their members conversations (see Config.PerUserGroups).
+func (b Bot) Language(chat Chat) LanguageType {
+	if lang := chat.Params.Get(LanguageParam); lang != "" {
+		return LanguageType(lang)
+	}
+	if chat.Type != "private" && !chat.member {
+		return b.Config.DefaultLanguage
+	}
+	if lang := b.userLanguage(chat.UserID); lang != "" {
+		return lang
+	}
+	return b.Config.DefaultLanguage
+}
+
+// userLanguage returns the last language_code of user,
+// it's loaded from saved user if it's not cached.
+func (b Bot) userLanguage(userID int) LanguageType {
+	lang, ok := b.languages.get(userID)
+	if ok || b.Config.Model == nil || userID == 0 {
+		return lang
+	}
+
+	user, err := b.Config.Model.UserByUserID(userID)
+	if err != nil {
+		return ""
+	}
+	lang = LanguageType(user.Params.Get(TelegramLanguageParam))
+	b.languages.load(userID, lang)
+	return lang
+}
+
+// Localize returns text translated to chat's language by Config.Catalog.
+func (b Bot) Localize(chat Chat, text string) string {
+	return b.catalog.localize(b.Language(chat), text)
+}
+
+// localizer returns Localize bound to chat.
+func (b Bot) localizer(chat Chat) func(string) string {
+	lang := b.Language(chat)
+	return func(text string) string {
+		return b.catalog.localize(lang, text)
+	}
+}
+
+const languageCodesMaxSize = 10000
+
+// languageCodes caches the last language_code of users, which is not
+// supported by tgbotapi, so it's read from raw updates. Changed codes
+// are saved to users Params by saveMember. Cache is reset when it grows
+// over languageCodesMaxSize, codes are loaded from users again.
+type languageCodes struct {
+	sync.RWMutex
+	m       map[int]LanguageType
+	changed map[int]bool
+}
+
+func newLanguageCodes() *languageCodes {
+	return &languageCodes{
+		m:       make(map[int]LanguageType),
+		changed: make(map[int]bool),
+	}
+}
+
+// get returns cached code of user, ok is false if it's not cached.
+func (l *languageCodes) get(userID int) (lang LanguageType, ok bool) {
+	if l == nil {
+		return "", true
+	}
+	l.RLock()
+	defer l.RUnlock()
+	lang, ok = l.m[userID]
+	return lang, ok
+}
+
+// load caches code of user loaded from model unless it's received already.
+func (l *languageCodes) load(userID int, lang LanguageType) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.reset()
+	if _, ok := l.m[userID]; !ok {
+		l.m[userID] = lang
+	}
+}
+
+// takeChanged returns code of user if it's changed since it was taken last time.
+func (l *languageCodes) takeChanged(userID int) (LanguageType, bool) {
+	if l == nil {
+		return "", false
+	}
+	l.Lock()
+	defer l.Unlock()
+	if !l.changed[userID] {
+		return "", false
+	}
+	delete(l.changed, userID)
+	return l.m[userID], true
+}
+
+// reset empties cache if it's too large, except changed codes
+// which are not saved yet, l should be locked.
+func (l *languageCodes) reset() {
+	if len(l.m) <= languageCodesMaxSize {
+		return
+	}
+	m := make(map[int]LanguageType, len(l.changed))
+	for userID := range l.changed {
+		m[userID] = l.m[userID]
+	}
+	l.m = m
+}
+
+type languageFrom struct {
+	From *struct {
+		ID           int          `json:"id"`
+		LanguageCode LanguageType `json:"language_code"`
+	} `json:"from"`
+}
+
+// scan saves language_code of update's sender.
+func (l *languageCodes) scan(rawUpdate []byte) {
+	if l == nil {
+		return
+	}
+
+	var update struct {
+		Message            *languageFrom `json:"message"`
+		EditedMessage      *languageFrom `json:"edited_message"`
+		CallbackQuery      *languageFrom `json:"callback_query"`
+		InlineQuery        *languageFrom `json:"inline_query"`
+		ChosenInlineResult *languageFrom `json:"chosen_inline_result"`
+	}
+	err := json.Unmarshal(rawUpdate, &update)
+	if err != nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.reset()
+	for _, from := range []*languageFrom{update.Message, update.EditedMessage,
+		update.CallbackQuery, update.InlineQuery, update.ChosenInlineResult} {
+		if from == nil || from.From == nil || from.From.LanguageCode == "" {
+			continue
+		}
+		if lang, ok := l.m[from.From.ID]; !ok || lang != from.From.LanguageCode {
+			l.m[from.From.ID] = from.From.LanguageCode
+			l.changed[from.From.ID] = true
+		}
+	}
+}
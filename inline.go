@@ -0,0 +1,137 @@
+package depechebot
+
+import (
+	"log"
+	"strconv"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// inlineResultsMax is Telegram's limit of results per inline answer.
+	inlineResultsMax = 50
+)
+
+// InlineAnswer is an answer to inline query. Results are
+// tgbotapi.InlineQueryResult* values, see NewInlineArticle and others.
+type InlineAnswer struct {
+	Results []interface{}
+	// NextOffset is sent back by Telegram as InlineQuery.Offset
+	// when user scrolls to the end of results. Empty if there are no more.
+	NextOffset string
+	// CacheTime is the time in seconds Telegram servers may cache the answer.
+	CacheTime int
+	// IsPersonal forbids sharing cached answer between users.
+	IsPersonal        bool
+	SwitchPMText      string
+	SwitchPMParameter string
+}
+
+// InlineHandler answers inline queries (@botname query).
+// Inline queries have no chat, so handler is not tied to any chat state.
+type InlineHandler func(Bot, tgbotapi.InlineQuery) InlineAnswer
+
+// ChosenInlineHandler is called when user chooses result of inline query.
+// Note that Telegram sends these only if inline feedback is enabled for the bot.
+type ChosenInlineHandler func(Bot, tgbotapi.ChosenInlineResult)
+
+func NewInlineArticle(id, title, text string) tgbotapi.InlineQueryResultArticle {
+	return tgbotapi.NewInlineQueryResultArticle(id, title, text)
+}
+
+func NewInlineArticleWithMarkdown(id, title, text string) tgbotapi.InlineQueryResultArticle {
+	return tgbotapi.NewInlineQueryResultArticleMarkdown(id, title, text)
+}
+
+func NewInlinePhoto(id, url, thumbURL string) tgbotapi.InlineQueryResultPhoto {
+	return tgbotapi.NewInlineQueryResultPhotoWithThumb(id, url, thumbURL)
+}
+
+func NewInlineDocument(id, url, title, mimeType string) tgbotapi.InlineQueryResultDocument {
+	return tgbotapi.NewInlineQueryResultDocument(id, url, title, mimeType)
+}
+
+// NewInlinePage returns answer with a page of results starting at offset
+// (as received in InlineQuery.Offset) and NextOffset pointing to the next page.
+// pageSize is limited to 50 results.
+func NewInlinePage(results []interface{}, offset string, pageSize int) InlineAnswer {
+	if pageSize <= 0 || pageSize > inlineResultsMax {
+		pageSize = inlineResultsMax
+	}
+
+	start, err := strconv.Atoi(offset)
+	if err != nil || start < 0 {
+		start = 0
+	}
+	if start > len(results) {
+		start = len(results)
+	}
+
+	end := start + pageSize
+	answer := InlineAnswer{}
+	if end < len(results) {
+		answer.NextOffset = strconv.Itoa(end)
+	} else {
+		end = len(results)
+	}
+	answer.Results = results[start:end]
+
+	return answer
+}
+
+// WithCache returns answer cached for cacheTime seconds,
+// personal for each user if personal is set.
+func (a InlineAnswer) WithCache(cacheTime int, personal bool) InlineAnswer {
+	newAnswer := a
+	newAnswer.CacheTime = cacheTime
+	newAnswer.IsPersonal = personal
+	return newAnswer
+}
+
+// goroutine
+func (b Bot) processInlineQuery(query tgbotapi.InlineQuery) {
+	defer b.life.handlers.Done()
+
+	if b.Config.InlineHandler == nil {
+		return
+	}
+
+	answer := b.Config.InlineHandler(b, query)
+	results := answer.Results
+	if results == nil {
+		results = []interface{}{}
+	}
+
+	_, err := b.api.AnswerInlineQuery(tgbotapi.InlineConfig{
+		InlineQueryID:     query.ID,
+		Results:           results,
+		CacheTime:         answer.CacheTime,
+		IsPersonal:        answer.IsPersonal,
+		NextOffset:        answer.NextOffset,
+		SwitchPMText:      answer.SwitchPMText,
+		SwitchPMParameter: answer.SwitchPMParameter,
+	})
+	if err != nil {
+		log.Printf("Failed to answer inline query %v: error \"%v\"\n", query.ID, err)
+	}
+}
+
+// goroutine
+func (b Bot) processChosenInlineResult(result tgbotapi.ChosenInlineResult) {
+	defer b.life.handlers.Done()
+
+	if b.Config.ChosenInlineHandler == nil {
+		return
+	}
+
+	b.Config.ChosenInlineHandler(b, result)
+}
+
+// goroutine
+// processInlineCallbackQuery answers callback query from inline message,
+// which has no chat to route it to, so it's limited as user's private chat.
+func (b Bot) processInlineCallbackQuery(query tgbotapi.CallbackQuery) {
+	defer b.life.handlers.Done()
+
+	b.answerCallbackQuery(&query, ChatID(query.From.ID))
+}
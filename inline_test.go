@@ -0,0 +1,41 @@
+package depechebot
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestNewInlinePage(t *testing.T) {
+	var results []interface{}
+	for i := 0; i < 120; i++ {
+		results = append(results, NewInlineArticle(strconv.Itoa(i), "title", "text"))
+	}
+
+	tests := []struct {
+		offset     string
+		pageSize   int
+		len        int
+		nextOffset string
+	}{
+		{"", 20, 20, "20"},
+		{"20", 20, 20, "40"},
+		{"100", 20, 20, ""},
+		{"110", 20, 10, ""},
+		{"500", 20, 0, ""},
+		{"garbage", 20, 20, "20"},
+		{"", 0, 50, "50"},
+		{"", 100, 50, "50"},
+	}
+
+	for _, test := range tests {
+		answer := NewInlinePage(results, test.offset, test.pageSize)
+		if len(answer.Results) != test.len {
+			t.Errorf("offset %q, page %d: got %d results, want %d",
+				test.offset, test.pageSize, len(answer.Results), test.len)
+		}
+		if answer.NextOffset != test.nextOffset {
+			t.Errorf("offset %q, page %d: got next offset %q, want %q",
+				test.offset, test.pageSize, answer.NextOffset, test.nextOffset)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package depechebot
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit is Count messages per Per, Count of them could be sent at once.
+type Limit struct {
+	Count int
+	Per   time.Duration
+}
+
+// RateLimits of sending messages, shared by all chats.
+// Zero limits are set to Telegram's defaults.
+type RateLimits struct {
+	// Global is 30 messages per second.
+	Global Limit
+	// Chat is 1 message per second for every chat.
+	Chat Limit
+	// Group is 20 messages per minute for every group chat,
+	// in addition to Chat limit.
+	Group Limit
+}
+
+const limiterSweepSize = 1000
+
+// limiter is token bucket limiter of all send paths, see Bot.send.
+type limiter struct {
+	mu      sync.Mutex
+	limits  RateLimits
+	global  bucket
+	chats   map[ChatID]*bucket
+	groups  map[ChatID]*bucket
+	waiting int
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+func newLimiter(limits RateLimits) *limiter {
+	if limits.Global.Count == 0 {
+		limits.Global = Limit{30, time.Second}
+	}
+	if limits.Chat.Count == 0 {
+		limits.Chat = Limit{1, time.Second}
+	}
+	if limits.Group.Count == 0 {
+		limits.Group = Limit{20, time.Minute}
+	}
+
+	return &limiter{
+		limits: limits,
+		global: newBucket(limits.Global),
+		chats:  make(map[ChatID]*bucket),
+		groups: make(map[ChatID]*bucket),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// wait blocks until message could be sent to the chat.
+func (l *limiter) wait(chatID ChatID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delay := l.reserve(chatID)
+	if delay == 0 {
+		return
+	}
+
+	l.waiting++
+	for delay != 0 {
+		l.mu.Unlock()
+		l.sleep(delay)
+		l.mu.Lock()
+		delay = l.reserve(chatID)
+	}
+	l.waiting--
+}
+
+// reserve takes tokens from global and chat's buckets if they are
+// available now, otherwise returns delay to try again after.
+// Tokens are not reserved in advance, so waiting for one chat
+// doesn't hold global limit for others.
+func (l *limiter) reserve(chatID ChatID) time.Duration {
+	now := l.now()
+	if len(l.chats) > limiterSweepSize {
+		sweep(l.chats, now)
+		sweep(l.groups, now)
+	}
+
+	buckets := []*bucket{&l.global, l.bucket(l.chats, chatID, l.limits.Chat)}
+	// group chats IDs are negative
+	if chatID < 0 {
+		buckets = append(buckets, l.bucket(l.groups, chatID, l.limits.Group))
+	}
+
+	at := now
+	for _, b := range buckets {
+		if t := b.at(now); t.After(at) {
+			at = t
+		}
+	}
+	if at.After(now) {
+		return at.Sub(now)
+	}
+
+	for _, b := range buckets {
+		b.take(now)
+	}
+	return 0
+}
+
+func (l *limiter) bucket(buckets map[ChatID]*bucket, chatID ChatID, limit Limit) *bucket {
+	b, ok := buckets[chatID]
+	if !ok {
+		newB := newBucket(limit)
+		b = &newB
+		buckets[chatID] = b
+	}
+	return b
+}
+
+// sweep deletes full buckets, they are the same as new ones.
+func sweep(buckets map[ChatID]*bucket, now time.Time) {
+	for chatID, b := range buckets {
+		if !b.tat.After(now) {
+			delete(buckets, chatID)
+		}
+	}
+}
+
+// bucket is token bucket implemented as generic cell rate algorithm:
+// tat is the time when bucket is full again.
+type bucket struct {
+	interval time.Duration // to get a token
+	burst    int
+	tat      time.Time
+}
+
+func newBucket(limit Limit) bucket {
+	return bucket{
+		interval: limit.Per / time.Duration(limit.Count),
+		burst:    limit.Count,
+	}
+}
+
+// at returns the earliest time not before now when token is available.
+func (b *bucket) at(now time.Time) time.Time {
+	t := b.tat.Add(-time.Duration(b.burst-1) * b.interval)
+	if t.Before(now) {
+		return now
+	}
+	return t
+}
+
+// take takes token at t.
+func (b *bucket) take(t time.Time) {
+	if b.tat.Before(t) {
+		b.tat = t
+	}
+	b.tat = b.tat.Add(b.interval)
+}
+
+// SendQueue is the number of messages waiting to be sent.
+type SendQueue struct {
+	// Send and Broad are queued in Bot.SendChan and Bot.SendBroadChan.
+	Send  int
+	Broad int
+	// Limited are delayed by rate limits.
+	Limited int
+}
+
+// SendQueue returns current queue depth.
+func (b Bot) SendQueue() SendQueue {
+	queue := SendQueue{
+		Send:  len(b.SendChan),
+		Broad: len(b.SendBroadChan),
+	}
+	if b.limiter != nil {
+		b.limiter.mu.Lock()
+		queue.Limited = b.limiter.waiting
+		b.limiter.mu.Unlock()
+	}
+
+	return queue
+}
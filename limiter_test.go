@@ -0,0 +1,64 @@
+package depechebot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTestLimiter := func(limits RateLimits) *limiter {
+		l := newLimiter(limits)
+		l.now = func() time.Time { return now }
+		return l
+	}
+
+	// chat limit, other chats are not delayed
+	l := newTestLimiter(RateLimits{})
+	for i, delay := range []time.Duration{0, time.Second, time.Second} {
+		if d := l.reserve(1); d != delay {
+			t.Errorf("%v: reserve(1) = %v, want %v", i, d, delay)
+		}
+	}
+	if d := l.reserve(2); d != 0 {
+		t.Errorf("reserve(2) = %v, want 0s", d)
+	}
+	now = now.Add(time.Second)
+	if d := l.reserve(1); d != 0 {
+		t.Errorf("reserve(1) after delay = %v, want 0s", d)
+	}
+
+	// group limit after its burst of 20, 1 message per 3 seconds
+	l = newTestLimiter(RateLimits{Chat: Limit{100, time.Second}})
+	for i := 0; i < 20; i++ {
+		if d := l.reserve(-1); d != 0 {
+			t.Fatalf("%v: reserve(-1) in group burst = %v", i, d)
+		}
+	}
+	if d := l.reserve(-1); d != 3*time.Second {
+		t.Errorf("reserve(-1) after group burst = %v, want 3s", d)
+	}
+
+	// global limit
+	l = newTestLimiter(RateLimits{})
+	for chatID := ChatID(100); chatID < 130; chatID++ {
+		if d := l.reserve(chatID); d != 0 {
+			t.Fatalf("reserve(%v) in global burst = %v", chatID, d)
+		}
+	}
+	if d := l.reserve(130); d != time.Second/30 {
+		t.Errorf("reserve(130) after global burst = %v, want %v", d, time.Second/30)
+	}
+
+	// full buckets are swept
+	l = newTestLimiter(RateLimits{})
+	for chatID := ChatID(-limiterSweepSize / 2); chatID <= limiterSweepSize/2; chatID++ {
+		now = now.Add(time.Second)
+		l.reserve(chatID)
+	}
+	now = now.Add(time.Hour)
+	l.reserve(1)
+	if len(l.chats) != 1 || len(l.groups) != 0 {
+		t.Errorf("%v chats and %v groups buckets after sweep, want 1 and 0", len(l.chats), len(l.groups))
+	}
+}
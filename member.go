@@ -0,0 +1,104 @@
+package depechebot
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// IsMemberChat reports whether chat is conversation of chat.UserID
+// in group chat, see Config.PerUserGroups.
+func (c Chat) IsMemberChat() bool {
+	return c.member
+}
+
+// UpdateGroupChat loads group chat, passes it to update and saves it.
+// Members conversations (see Config.PerUserGroups) should use it
+// to change group-wide Params or Data, as updates of all members
+// are serialized.
+func (b Bot) UpdateGroupChat(chatID ChatID, update func(*Chat)) error {
+	b.groups.Lock()
+	defer b.groups.Unlock()
+
+	chat, err := b.Config.Model.ChatByChatID(chatID)
+	if err != nil {
+		return err
+	}
+	update(chat)
+
+	return b.Config.Model.Update(chat)
+}
+
+// updateGroupFromMember updates group chat as updateChat does.
+// Abandoned is changed only if bot left or joined the group,
+// as members conversations know nothing about abandoning by sends.
+func (b Bot) updateGroupFromMember(member *Chat, update tgbotapi.Update) {
+	if b.Config.Model == nil {
+		return
+	}
+
+	msg := update.Message
+	leftOrJoined := msg != nil && (msg.LeftChatMember != nil ||
+		msg.NewChatMember != nil && msg.NewChatMember.ID == b.api.Self.ID)
+	err := b.UpdateGroupChat(member.ChatID, func(chat *Chat) {
+		if leftOrJoined {
+			chat.Abandoned = member.Abandoned
+		}
+		chat.UserID = member.UserID
+		chat.UserName = member.UserName
+		chat.FirstName = member.FirstName
+		chat.LastName = member.LastName
+		chat.LastTime = member.LastTime
+	})
+	if err != nil {
+		log.Printf("Failed to update group chat %v: error \"%v\"\n", member.ChatID, err)
+	}
+}
+
+// openMemberChat saves group chat and user's conversation in it if they are new.
+func (b Bot) openMemberChat(tgChat *tgbotapi.Chat, from *tgbotapi.User) {
+	chatID := ChatID(tgChat.ID)
+	now := time.Now()
+	chat := &Chat{
+		ChatID:    chatID,
+		Abandoned: false,
+		Type:      tgChat.Type,
+		UserID:    from.ID,
+		UserName:  from.UserName,
+		FirstName: from.FirstName,
+		LastName:  from.LastName,
+		OpenTime:  now,
+		LastTime:  now,
+		State:     StartState,
+		Params:    Params{},
+		Data:      Data{},
+	}
+
+	exists, err := b.Config.Model.Exists(chat)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !exists {
+		err = b.Config.Model.Insert(chat)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	_, err = b.Config.Model.MemberChat(chatID, from.ID)
+	if err == nil {
+		return
+	}
+	if err != ErrNoMemberChat {
+		log.Panic(err)
+	}
+
+	b.saveMember(chatID, from)
+	chat.Params = Params{}
+	chat.Data = Data{}
+	err = b.Config.Model.SaveMemberChat(chat)
+	if err != nil {
+		log.Panic(err)
+	}
+}
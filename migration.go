@@ -0,0 +1,111 @@
+package depechebot
+
+import (
+	"log"
+	"sync"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// chatMigrations maps ChatIDs of migrated group chats to supergroups ones,
+// shared by all copies of Bot. Chats goroutines apply them on their own,
+// so that custom StateActions.While knows nothing about migrations.
+type chatMigrations struct {
+	sync.RWMutex
+	m map[ChatID]ChatID
+}
+
+func newChatMigrations() *chatMigrations {
+	return &chatMigrations{m: make(map[ChatID]ChatID)}
+}
+
+// add calls move and adds migration if it succeeds. Chats are not saved
+// meanwhile, so they are saved either before move or to the new ChatID.
+func (m *chatMigrations) add(from, to ChatID, move func() error) error {
+	m.Lock()
+	defer m.Unlock()
+
+	err := move()
+	if err != nil {
+		return err
+	}
+	m.m[from] = to
+	return nil
+}
+
+// apply moves chat to supergroup if it's migrated.
+func (m *chatMigrations) apply(chat *Chat) {
+	if m == nil {
+		return
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+	m.applyLocked(chat)
+}
+
+// save applies migrations and calls save, with no migration in between.
+func (m *chatMigrations) save(chat *Chat, save func(*Chat) error) error {
+	if m == nil {
+		return save(chat)
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+	m.applyLocked(chat)
+	return save(chat)
+}
+
+func (m *chatMigrations) applyLocked(chat *Chat) {
+	to, ok := m.m[chat.ChatID]
+	if ok {
+		chat.ChatID = to
+		chat.Type = "supergroup"
+	}
+}
+
+// migrateChat moves group chat to supergroup in model, and its goroutines
+// (group's or members ones) to new ChatID, see chatMigrations.
+func (b Bot) migrateChat(message *tgbotapi.Message) {
+	from, to := ChatID(message.Chat.ID), ChatID(message.MigrateToChatID)
+	if message.MigrateFromChatID != 0 {
+		from, to = ChatID(message.MigrateFromChatID), ChatID(message.Chat.ID)
+	}
+
+	exists, err := b.Config.Model.Exists(&Chat{ChatID: from})
+	if err != nil {
+		log.Panic(err)
+	}
+	if !exists {
+		// already migrated on the other service message, or unknown chat
+		return
+	}
+
+	err = b.migrations.add(from, to, func() error {
+		return b.Config.Model.MigrateChat(from, to)
+	})
+	if err != nil {
+		log.Printf("Failed to migrate chat %v to %v: error \"%v\"\n", from, to, err)
+		return
+	}
+	log.Printf("Migrated chat %v to %v\n", from, to)
+
+	b.chatsChans.Lock()
+	for key, chatChan := range b.chatsChans.m {
+		if key.ChatID == from {
+			delete(b.chatsChans.m, key)
+			key.ChatID = to
+			b.chatsChans.m[key] = chatChan
+		}
+	}
+	b.chatsChans.Unlock()
+
+	if b.Config.ChatMigrated != nil {
+		chat, err := b.Config.Model.ChatByChatID(to)
+		if err != nil {
+			log.Printf("Failed to load migrated chat %v: error \"%v\"\n", to, err)
+			return
+		}
+		b.Config.ChatMigrated(b, from, *chat)
+	}
+}
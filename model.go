@@ -1,7 +1,10 @@
 // package model represents Model for depechebot chats data
 package depechebot
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 // Model of depechebot data.
 type Model interface {
@@ -11,22 +14,81 @@ type Model interface {
 
 	Exists(*Chat) (bool, error)
 	Insert(*Chat) error
+	// Update updates chat by its ChatID, returns ErrNoChat if there is no such.
 	Update(*Chat) error
 	Save(*Chat) error
 	Delete(*Chat) error
+	// MigrateChat moves group chat to supergroup's ChatID atomically, with its
+	// members and their conversations. Fails if chat with ChatID to exists.
+	MigrateChat(from, to ChatID) error
 
 	ChatByPrimaryID(id int) (*Chat, error)
 	ChatByChatID(id ChatID) (*Chat, error)
-	ChatsByParam(param string) ([]*Chat, error)
+	// ChatsByQuery retrieves chats matching q, ordered by PrimaryID.
+	// Returns empty slice if there are none.
+	ChatsByQuery(q Query) ([]*Chat, error)
+
+	// SaveUser inserts or updates user by UserID and sets u.PrimaryID.
+	// OpenTime of existing user is kept. Nil u.Params are kept too,
+	// so profile could be updated without loading the user first.
+	SaveUser(u *User) error
+	// DeleteUser deletes user with its chats membership.
+	DeleteUser(u *User) error
+	UserByUserID(id int) (*User, error)
+
+	// AddMember adds user to chat members or updates its last activity time.
+	AddMember(chatID ChatID, userID int, t time.Time) error
+	RemoveMember(chatID ChatID, userID int) error
+	// UsersByChatID retrieves saved users which are members of chat, ordered by PrimaryID.
+	UsersByChatID(id ChatID) ([]*User, error)
+	// ChatsByUserID retrieves chats the user is member of, ordered by PrimaryID.
+	ChatsByUserID(id int) ([]*Chat, error)
+
+	// MemberChat retrieves conversation of user in group chat (see Config.PerUserGroups):
+	// group chat with member's UserID, names, State, Params and Data,
+	// OpenTime is member's join time. Returns ErrNoMemberChat if it's not saved.
+	MemberChat(chatID ChatID, userID int) (*Chat, error)
+	// SaveMemberChat saves State, Params, Data and LastTime of c.UserID conversation
+	// in group c.ChatID, adding user to chat members if needed.
+	SaveMemberChat(c *Chat) error
 }
 
+// BroadcastStore is implemented by Model which saves broadcasts,
+// it's needed by Bot.StartBroadcast and other broadcast methods.
+type BroadcastStore interface {
+	// InsertBroadcast inserts broadcast with pending recipients and sets bc.ID.
+	InsertBroadcast(bc *Broadcast, chatIDs []ChatID) error
+	// UpdateBroadcast updates Status and UpdateTime of broadcast.
+	UpdateBroadcast(bc *Broadcast) error
+	BroadcastByID(id int) (*Broadcast, error)
+	// BroadcastsByStatus retrieves broadcasts with status, ordered by ID.
+	BroadcastsByStatus(status BroadcastStatus) ([]*Broadcast, error)
+	// UpdateRecipient updates status of broadcast's recipient r.ChatID.
+	UpdateRecipient(id int, r Recipient) error
+	// RecipientsByBroadcastID retrieves recipients of broadcast in order they were inserted.
+	RecipientsByBroadcastID(id int) ([]Recipient, error)
+}
+
+// Flusher is implemented by Model which writes changes in background,
+// Run flushes it on shutdown.
+type Flusher interface {
+	Flush() error
+}
+
+// ErrNoChat is returned by Model.Update for chat which is not saved,
+// e.g. moved to another ChatID.
+var ErrNoChat = errors.New("chat is not saved")
+
+// ErrNoMemberChat is returned by Model.MemberChat for user without conversation in chat.
+var ErrNoMemberChat = errors.New("member chat not found")
+
 // Chat represents a row from 'chat'.
 type Chat struct {
 	PrimaryID int       `json:"primary_id"`
 	ChatID    ChatID    `json:"chat_id"`
 	Type      string    `json:"type"`
 	Abandoned bool      `json:"abandoned"`
-	UserID    int       `json:"user_id"`
+	UserID    int       `json:"user_id"` // who spoke last, see User for group members
 	UserName  string    `json:"user_name"`
 	FirstName string    `json:"first_name"`
 	LastName  string    `json:"last_name"`
@@ -34,6 +96,23 @@ type Chat struct {
 	LastTime  time.Time `json:"last_time"`
 	State     State     `json:"state"`
 	Params    Params    `json:"params"`
+	Data      Data      `json:"data"`
+
+	member bool // conversation of UserID in group chat
+}
+
+// User represents a row from 'tg_user'.
+// Unlike Chat.UserID and names, which are of whoever spoke last,
+// it's a profile of one Telegram user shared by all chats.
+type User struct {
+	PrimaryID int       `json:"primary_id"`
+	UserID    int       `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	OpenTime  time.Time `json:"open_time"`
+	LastTime  time.Time `json:"last_time"`
+	Params    Params    `json:"params"`
 }
 
 // Params
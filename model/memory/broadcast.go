@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	dbot "github.com/depechebot/depechebot"
+)
+
+type broadcastRecord struct {
+	dbot.Broadcast
+	Recipients []dbot.Recipient `json:"recipients"`
+
+	recipients map[dbot.ChatID]int // index in Recipients
+}
+
+func (r *broadcastRecord) index() {
+	r.recipients = make(map[dbot.ChatID]int, len(r.Recipients))
+	for i, recipient := range r.Recipients {
+		r.recipients[recipient.ChatID] = i
+	}
+}
+
+// InsertBroadcast inserts broadcast with pending recipients and sets bc.ID.
+func (m *Model) InsertBroadcast(bc *dbot.Broadcast, chatIDs []dbot.ChatID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// fail as other models on messages which couldn't be saved
+	_, err := json.Marshal(bc.Message)
+	if err != nil {
+		return err
+	}
+
+	m.lastBroadcastID++
+	bc.ID = m.lastBroadcastID
+	record := &broadcastRecord{Broadcast: *bc, Recipients: []dbot.Recipient{}}
+	for _, chatID := range chatIDs {
+		record.Recipients = append(record.Recipients,
+			dbot.Recipient{ChatID: chatID, Status: dbot.RecipientPending})
+	}
+	record.index()
+	m.broadcasts[bc.ID] = record
+
+	return m.store()
+}
+
+// UpdateBroadcast updates Status and UpdateTime of broadcast.
+func (m *Model) UpdateBroadcast(bc *dbot.Broadcast) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.broadcasts[bc.ID]
+	if !ok {
+		return nil
+	}
+	record.Status = bc.Status
+	record.UpdateTime = bc.UpdateTime
+
+	return m.store()
+}
+
+// BroadcastByID retrieves a broadcast by id.
+func (m *Model) BroadcastByID(id int) (*dbot.Broadcast, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.broadcasts[id]
+	if !ok {
+		return nil, errors.New("broadcast not found")
+	}
+	bc := record.Broadcast
+
+	return &bc, nil
+}
+
+// BroadcastsByStatus retrieves broadcasts with status.
+func (m *Model) BroadcastsByStatus(status dbot.BroadcastStatus) ([]*dbot.Broadcast, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bcs := []*dbot.Broadcast{}
+	for _, record := range m.sortedBroadcasts() {
+		if record.Status == status {
+			bc := record.Broadcast
+			bcs = append(bcs, &bc)
+		}
+	}
+
+	return bcs, nil
+}
+
+// UpdateRecipient updates status of broadcast's recipient r.ChatID.
+func (m *Model) UpdateRecipient(id int, r dbot.Recipient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.broadcasts[id]
+	if !ok {
+		return nil
+	}
+	i, ok := record.recipients[r.ChatID]
+	if !ok {
+		return nil
+	}
+	record.Recipients[i] = r
+
+	return m.store()
+}
+
+// RecipientsByBroadcastID retrieves recipients of broadcast.
+func (m *Model) RecipientsByBroadcastID(id int) ([]dbot.Recipient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.broadcasts[id]
+	if !ok {
+		return []dbot.Recipient{}, nil
+	}
+
+	return append([]dbot.Recipient{}, record.Recipients...), nil
+}
+
+func (m *Model) sortedBroadcasts() []*broadcastRecord {
+	records := make([]*broadcastRecord, 0, len(m.broadcasts))
+	for _, record := range m.broadcasts {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	return records
+}
@@ -0,0 +1,613 @@
+// Package memory implements depechebot Model keeping chats in memory,
+// optionally with JSON snapshot on disk. It's handy for tests,
+// prototypes and ephemeral bots.
+//
+// Snapshot is written in SnapshotDelay after changes, so that frequent
+// updates cost one write. Bot.Run flushes it on shutdown, other users
+// of the model should call Flush or Close.
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	dbot "github.com/depechebot/depechebot"
+)
+
+// Model is goroutine-safe in-memory Model.
+type Model struct {
+	mu                sync.RWMutex
+	chats             map[dbot.ChatID]*dbot.Chat
+	lastPrimaryID     int
+	users             map[int]*dbot.User
+	lastUserPrimaryID int
+	members           map[member]*memberRecord
+	broadcasts        map[int]*broadcastRecord
+	lastBroadcastID   int
+	snapshot          string
+
+	// dirty, timer and closed are guarded by mu,
+	// writeMu serializes snapshot writes
+	dirty   bool
+	timer   *time.Timer
+	closed  bool
+	writeMu sync.Mutex
+}
+
+// SnapshotDelay is time after change the snapshot is written in.
+var SnapshotDelay = time.Second
+
+type member struct {
+	ChatID dbot.ChatID `json:"chat_id"`
+	UserID int         `json:"user_id"`
+}
+
+type memberRecord struct {
+	JoinTime time.Time `json:"join_time"`
+	LastTime time.Time `json:"last_time"`
+	// member's conversation, State is nil if there is none
+	State  *dbot.State `json:"state,omitempty"`
+	Params dbot.Params `json:"params"`
+	Data   dbot.Data   `json:"data"`
+}
+
+type snapshotMember struct {
+	member
+	memberRecord
+}
+
+type snapshotData struct {
+	LastPrimaryID     int                `json:"last_primary_id"`
+	Chats             []*dbot.Chat       `json:"chats"`
+	LastUserPrimaryID int                `json:"last_user_primary_id"`
+	Users             []*dbot.User       `json:"users"`
+	Members           []snapshotMember   `json:"members"`
+	LastBroadcastID   int                `json:"last_broadcast_id"`
+	Broadcasts        []*broadcastRecord `json:"broadcasts"`
+}
+
+// NewModel returns model without snapshot.
+func NewModel() *Model {
+	return NewModelWithSnapshot("")
+}
+
+// NewModelWithSnapshot returns model which loads chats from JSON file
+// on Init and writes them to it in SnapshotDelay after changes.
+func NewModelWithSnapshot(path string) *Model {
+	return &Model{
+		chats:      make(map[dbot.ChatID]*dbot.Chat),
+		users:      make(map[int]*dbot.User),
+		members:    make(map[member]*memberRecord),
+		broadcasts: make(map[int]*broadcastRecord),
+		snapshot:   path,
+	}
+}
+
+// Init initializes model.
+// chatIDs stores existing chats ChatID.
+func (m *Model) Init() (chatIDs []dbot.ChatID, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err = m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	chatIDs = []dbot.ChatID{}
+	for _, c := range m.sorted() {
+		chatIDs = append(chatIDs, c.ChatID)
+	}
+
+	return chatIDs, nil
+}
+
+// Exists determines if the Chat exists in the model.
+func (m *Model) Exists(c *dbot.Chat) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.chats[c.ChatID]
+	return ok, nil
+}
+
+// Insert inserts chat to the model.
+// Sets c.PrimaryID.
+func (m *Model) Insert(c *dbot.Chat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chats[c.ChatID]; ok {
+		return errors.New("chat already exists")
+	}
+
+	m.lastPrimaryID++
+	c.PrimaryID = m.lastPrimaryID
+	m.chats[c.ChatID] = clone(c)
+
+	return m.store()
+}
+
+// Update updates the Chat in the model.
+func (m *Model) Update(c *dbot.Chat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chats[c.ChatID]; !ok {
+		return dbot.ErrNoChat
+	}
+	m.chats[c.ChatID] = clone(c)
+
+	return m.store()
+}
+
+// Save saves the Chat to the model.
+// Prefer Update() if you know that chat exists.
+func (m *Model) Save(c *dbot.Chat) error {
+	exists, err := m.Exists(c)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return m.Update(c)
+	}
+
+	return m.Insert(c)
+}
+
+// Delete deletes the Chat from the model.
+func (m *Model) Delete(c *dbot.Chat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chats[c.ChatID]; !ok {
+		return nil
+	}
+	delete(m.chats, c.ChatID)
+	for mem := range m.members {
+		if mem.ChatID == c.ChatID {
+			delete(m.members, mem)
+		}
+	}
+
+	return m.store()
+}
+
+// MigrateChat moves group chat to supergroup's ChatID.
+func (m *Model) MigrateChat(from, to dbot.ChatID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chats[to]; ok {
+		return fmt.Errorf("chat %v already exists", to)
+	}
+	c, ok := m.chats[from]
+	if !ok {
+		return nil
+	}
+
+	delete(m.chats, from)
+	c.ChatID = to
+	c.Type = "supergroup"
+	m.chats[to] = c
+
+	members := make(map[member]*memberRecord)
+	for mem, record := range m.members {
+		switch mem.ChatID {
+		case to:
+			// stale members of missing chat
+		case from:
+			members[member{to, mem.UserID}] = record
+		default:
+			members[mem] = record
+		}
+	}
+	m.members = members
+
+	return m.store()
+}
+
+// ChatByPrimaryID retrieves a chat by primaryID.
+func (m *Model) ChatByPrimaryID(primaryID int) (*dbot.Chat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, c := range m.chats {
+		if c.PrimaryID == primaryID {
+			return clone(c), nil
+		}
+	}
+
+	return nil, errors.New("chat not found (use Model.Exist() before if not sure)")
+}
+
+// ChatByChatID retrieves a chat by chatID.
+func (m *Model) ChatByChatID(chatID dbot.ChatID) (*dbot.Chat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.chats[chatID]
+	if !ok {
+		return nil, errors.New("chat not found (use Model.Exist() before if not sure)")
+	}
+
+	return clone(c), nil
+}
+
+// ChatsByQuery retrieves chats matching q.
+func (m *Model) ChatsByQuery(q dbot.Query) ([]*dbot.Chat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chats := []*dbot.Chat{}
+	for _, c := range m.sorted() {
+		if q.Match(c) {
+			chats = append(chats, clone(c))
+		}
+	}
+
+	return chats, nil
+}
+
+// SaveUser inserts or updates user by UserID and sets u.PrimaryID.
+func (m *Model) SaveUser(u *dbot.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	newUser := cloneUser(u)
+	if old, ok := m.users[u.UserID]; ok {
+		newUser.PrimaryID = old.PrimaryID
+		newUser.OpenTime = old.OpenTime
+		if newUser.Params == nil {
+			newUser.Params = cloneParams(old.Params)
+		}
+	} else {
+		m.lastUserPrimaryID++
+		newUser.PrimaryID = m.lastUserPrimaryID
+		if newUser.Params == nil {
+			newUser.Params = dbot.Params{}
+		}
+	}
+	m.users[u.UserID] = newUser
+	u.PrimaryID = newUser.PrimaryID
+
+	return m.store()
+}
+
+// DeleteUser deletes user with its chats membership.
+func (m *Model) DeleteUser(u *dbot.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.users, u.UserID)
+	for mem := range m.members {
+		if mem.UserID == u.UserID {
+			delete(m.members, mem)
+		}
+	}
+
+	return m.store()
+}
+
+// UserByUserID retrieves a user by userID.
+func (m *Model) UserByUserID(userID int) (*dbot.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+
+	return cloneUser(u), nil
+}
+
+// AddMember adds user to chat members or updates its last activity time.
+func (m *Model) AddMember(chatID dbot.ChatID, userID int, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.members[member{chatID, userID}]; ok {
+		record.LastTime = t
+	} else {
+		m.members[member{chatID, userID}] = &memberRecord{JoinTime: t, LastTime: t}
+	}
+
+	return m.store()
+}
+
+// RemoveMember removes user from chat members.
+func (m *Model) RemoveMember(chatID dbot.ChatID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.members, member{chatID, userID})
+
+	return m.store()
+}
+
+// UsersByChatID retrieves saved users which are members of chat.
+func (m *Model) UsersByChatID(chatID dbot.ChatID) ([]*dbot.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := []*dbot.User{}
+	for _, u := range m.sortedUsers() {
+		if _, ok := m.members[member{chatID, u.UserID}]; ok {
+			users = append(users, cloneUser(u))
+		}
+	}
+
+	return users, nil
+}
+
+// ChatsByUserID retrieves chats the user is member of.
+func (m *Model) ChatsByUserID(userID int) ([]*dbot.Chat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chats := []*dbot.Chat{}
+	for _, c := range m.sorted() {
+		if _, ok := m.members[member{c.ChatID, userID}]; ok {
+			chats = append(chats, clone(c))
+		}
+	}
+
+	return chats, nil
+}
+
+// sorted returns chats in insertion order.
+func (m *Model) sorted() []*dbot.Chat {
+	chats := make([]*dbot.Chat, 0, len(m.chats))
+	for _, c := range m.chats {
+		chats = append(chats, c)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return chats[i].PrimaryID < chats[j].PrimaryID
+	})
+
+	return chats
+}
+
+// MemberChat retrieves conversation of user in group chat.
+func (m *Model) MemberChat(chatID dbot.ChatID, userID int) (*dbot.Chat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.members[member{chatID, userID}]
+	c, chatOK := m.chats[chatID]
+	if !ok || !chatOK || record.State == nil {
+		return nil, dbot.ErrNoMemberChat
+	}
+
+	chat := clone(c)
+	chat.UserID = userID
+	chat.UserName, chat.FirstName, chat.LastName = "", "", ""
+	if u, ok := m.users[userID]; ok {
+		chat.UserName, chat.FirstName, chat.LastName = u.UserName, u.FirstName, u.LastName
+	}
+	chat.OpenTime = record.JoinTime
+	chat.LastTime = record.LastTime
+	chat.State = dbot.State{Name: record.State.Name, Params: cloneParams(record.State.Params)}
+	chat.Params = cloneParams(record.Params)
+	chat.Data = record.Data.Clone()
+
+	return chat, nil
+}
+
+// SaveMemberChat saves conversation of c.UserID in group c.ChatID.
+func (m *Model) SaveMemberChat(c *dbot.Chat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.members[member{c.ChatID, c.UserID}]
+	if !ok {
+		record = &memberRecord{JoinTime: c.LastTime}
+		m.members[member{c.ChatID, c.UserID}] = record
+	}
+	record.LastTime = c.LastTime
+	record.State = &dbot.State{Name: c.State.Name, Params: cloneParams(c.State.Params)}
+	record.Params = cloneParams(c.Params)
+	record.Data = c.Data.Clone()
+
+	return m.store()
+}
+
+func (m *Model) sortedUsers() []*dbot.User {
+	users := make([]*dbot.User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].PrimaryID < users[j].PrimaryID
+	})
+
+	return users
+}
+
+// load reads snapshot if any.
+func (m *Model) load() error {
+	if m.snapshot == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(m.snapshot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot snapshotData
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return err
+	}
+
+	m.chats = make(map[dbot.ChatID]*dbot.Chat)
+	for _, c := range snapshot.Chats {
+		m.chats[c.ChatID] = c
+	}
+	m.lastPrimaryID = snapshot.LastPrimaryID
+	m.users = make(map[int]*dbot.User)
+	for _, u := range snapshot.Users {
+		m.users[u.UserID] = u
+	}
+	m.lastUserPrimaryID = snapshot.LastUserPrimaryID
+	m.members = make(map[member]*memberRecord)
+	for _, mem := range snapshot.Members {
+		record := mem.memberRecord
+		m.members[mem.member] = &record
+	}
+	m.lastBroadcastID = snapshot.LastBroadcastID
+	m.broadcasts = make(map[int]*broadcastRecord)
+	for _, record := range snapshot.Broadcasts {
+		record.index()
+		m.broadcasts[record.ID] = record
+	}
+
+	return nil
+}
+
+// store schedules snapshot writing if any, m.mu should be locked.
+func (m *Model) store() error {
+	if m.snapshot == "" {
+		return nil
+	}
+
+	m.dirty = true
+	if m.timer == nil && !m.closed {
+		m.timer = time.AfterFunc(SnapshotDelay, func() {
+			m.Flush()
+		})
+	}
+	return nil
+}
+
+// Flush writes snapshot if there are changes since it was written.
+func (m *Model) Flush() error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	m.mu.Lock()
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	data, err := m.marshal()
+	if err == nil {
+		m.dirty = false
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = m.write(data)
+	if err != nil {
+		// written with the next change or Flush
+		m.mu.Lock()
+		m.dirty = true
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Close flushes snapshot, it's not written in background anymore.
+func (m *Model) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	return m.Flush()
+}
+
+// marshal returns JSON snapshot of the model, m.mu should be locked.
+func (m *Model) marshal() ([]byte, error) {
+	members := []snapshotMember{}
+	for mem, record := range m.members {
+		members = append(members, snapshotMember{mem, *record})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].ChatID != members[j].ChatID {
+			return members[i].ChatID < members[j].ChatID
+		}
+		return members[i].UserID < members[j].UserID
+	})
+
+	return json.Marshal(snapshotData{
+		LastPrimaryID:     m.lastPrimaryID,
+		Chats:             m.sorted(),
+		LastUserPrimaryID: m.lastUserPrimaryID,
+		Users:             m.sortedUsers(),
+		Members:           members,
+		LastBroadcastID:   m.lastBroadcastID,
+		Broadcasts:        m.sortedBroadcasts(),
+	})
+}
+
+// write writes snapshot data, m.writeMu should be locked.
+func (m *Model) write(data []byte) error {
+	// write and rename, so snapshot is never left half-written
+	tmp, err := ioutil.TempFile(filepath.Dir(m.snapshot), filepath.Base(m.snapshot))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.snapshot)
+}
+
+// clone returns deep copy of chat, as if it was saved and loaded.
+func clone(c *dbot.Chat) *dbot.Chat {
+	newChat := *c
+	newChat.State = dbot.State{
+		Name:   c.State.Name,
+		Params: cloneParams(c.State.Params),
+	}
+	newChat.Params = cloneParams(c.Params)
+	newChat.Data = c.Data.Clone()
+
+	return &newChat
+}
+
+func cloneUser(u *dbot.User) *dbot.User {
+	newUser := *u
+	newUser.Params = cloneParams(u.Params)
+
+	return &newUser
+}
+
+func cloneParams(params dbot.Params) dbot.Params {
+	if params == nil {
+		return nil
+	}
+
+	newParams := dbot.Params{}
+	for key, value := range params {
+		newParams[key] = value
+	}
+
+	return newParams
+}
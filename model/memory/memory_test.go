@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dbot "github.com/depechebot/depechebot"
+	"github.com/depechebot/depechebot/model/modeltest"
+)
+
+func TestMemoryModel(t *testing.T) {
+	modeltest.Run(t, func(t *testing.T) dbot.Model {
+		return NewModel()
+	})
+}
+
+func TestMemoryModelSnapshotConformance(t *testing.T) {
+	modeltest.Run(t, func(t *testing.T) dbot.Model {
+		m := NewModelWithSnapshot(filepath.Join(t.TempDir(), "chats.json"))
+		// before TempDir is removed
+		t.Cleanup(func() { m.Close() })
+		return m
+	})
+}
+
+func TestMemoryModelSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chats.json")
+
+	m := NewModelWithSnapshot(path)
+	_, err := m.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chatID := range []dbot.ChatID{1, 2, 3} {
+		err = m.Insert(&dbot.Chat{ChatID: chatID, State: dbot.StartState, Params: dbot.Params{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = m.Delete(&dbot.Chat{ChatID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changes are written together after SnapshotDelay
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("snapshot is written before SnapshotDelay: %v", err)
+	}
+	err = m.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = NewModelWithSnapshot(path)
+	chatIDs, err := m.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chatIDs) != 2 || chatIDs[0] != 1 || chatIDs[1] != 3 {
+		t.Errorf("loaded chats %v, want [1 3]", chatIDs)
+	}
+
+	chat := &dbot.Chat{ChatID: 4}
+	err = m.Insert(chat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.PrimaryID != 4 {
+		t.Errorf("inserted chat with primary id %v, want 4", chat.PrimaryID)
+	}
+
+	// written in background
+	deadline := time.Now().Add(5 * SnapshotDelay)
+	for {
+		chatIDs, err = NewModelWithSnapshot(path).Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chatIDs) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot is not written in background, loaded chats %v", chatIDs)
+		}
+		time.Sleep(SnapshotDelay / 10)
+	}
+
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
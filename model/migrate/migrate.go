@@ -0,0 +1,203 @@
+// Package migrate applies versioned schema migrations for SQL models.
+// Applied versions are tracked in schema_version table.
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const versionTable = `schema_version`
+
+// Migration is a schema change. Statements are executed in order
+// within one transaction.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Migrator applies migrations to db.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// Dollar uses $1 placeholders and catalog of Postgres instead of SQLite's.
+	Dollar bool
+}
+
+// Version returns current schema version, 0 for fresh database.
+// Database is not changed.
+func (m Migrator) Version() (int, error) {
+	exists, err := m.versionTableExists()
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = m.DB.QueryRow(`SELECT max(version) FROM ` + versionTable).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// Pending returns migrations not applied yet. Database is not changed.
+func (m Migrator) Pending() ([]Migration, error) {
+	err := m.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	last := 0
+	if len(m.Migrations) != 0 {
+		last = m.Migrations[len(m.Migrations)-1].Version
+	}
+	if version > last {
+		return nil, fmt.Errorf("migrate: database schema version %d is newer than supported %d", version, last)
+	}
+
+	pending := []Migration{}
+	for _, migration := range m.Migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Up applies pending migrations.
+func (m Migrator) Up() error {
+	err := m.createVersionTable()
+	if err != nil {
+		return err
+	}
+
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		err = m.apply(migration)
+		if err != nil {
+			return fmt.Errorf("migrate: version %d (%s): %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// DryRun writes SQL of pending migrations to w without applying them,
+// with creation of schema_version table if it doesn't exist.
+// Database is not changed.
+func (m Migrator) DryRun(w io.Writer) error {
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+
+	exists, err := m.versionTableExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err = fmt.Fprintf(w, "-- %s table\n%s;\n\n", versionTable, strings.TrimRight(strings.TrimSpace(versionTableSQL), ";"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, migration := range pending {
+		_, err = fmt.Fprintf(w, "-- version %d: %s\n", migration.Version, migration.Name)
+		if err != nil {
+			return err
+		}
+		for _, statement := range migration.Statements {
+			_, err = fmt.Fprintf(w, "%s;\n", strings.TrimRight(strings.TrimSpace(statement), ";"))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintln(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m Migrator) apply(migration Migration) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range migration.Statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	sqlstr := `INSERT INTO ` + versionTable + ` (version, name) VALUES (?, ?)`
+	if m.Dollar {
+		sqlstr = `INSERT INTO ` + versionTable + ` (version, name) VALUES ($1, $2)`
+	}
+	_, err = tx.Exec(sqlstr, migration.Version, migration.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const versionTableSQL = `CREATE TABLE IF NOT EXISTS ` +
+	versionTable +
+	` (
+  version INTEGER NOT NULL PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+func (m Migrator) createVersionTable() error {
+	_, err := m.DB.Exec(versionTableSQL)
+	return err
+}
+
+func (m Migrator) versionTableExists() (bool, error) {
+	sqlstr := `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if m.Dollar {
+		sqlstr = `SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`
+	}
+
+	var count int
+	err := m.DB.QueryRow(sqlstr, versionTable).Scan(&count)
+	return count != 0, err
+}
+
+// validate checks that versions are positive and strictly increasing.
+func (m Migrator) validate() error {
+	previous := 0
+	for _, migration := range m.Migrations {
+		if migration.Version <= previous {
+			return fmt.Errorf("migrate: version %d (%s) is not greater than previous %d",
+				migration.Version, migration.Name, previous)
+		}
+		previous = migration.Version
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testMigrations = []Migration{
+	{1, "create foo", []string{`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL)`}},
+	{2, "fill foo", []string{`INSERT INTO foo (name) VALUES ('a')`, `INSERT INTO foo (name) VALUES ('b')`}},
+	{5, "rename foo", []string{`ALTER TABLE foo RENAME TO bar`}},
+}
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigratorUp(t *testing.T) {
+	db := openDB(t)
+
+	m := Migrator{DB: db, Migrations: testMigrations[:2]}
+	err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Migrations = testMigrations
+	var out bytes.Buffer
+	err = m.DryRun(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "-- version 5: rename foo\nALTER TABLE foo RENAME TO bar;\n\n" {
+		t.Errorf("unexpected dry run output:\n%s", out.String())
+	}
+
+	for i := 0; i < 2; i++ {
+		err = m.Up()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 5 {
+		t.Errorf("got version %d, want 5", version)
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM bar`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("migration 2 is applied %d times", count/2)
+	}
+}
+
+func TestMigratorDryRunFresh(t *testing.T) {
+	db := openDB(t)
+
+	m := Migrator{DB: db, Migrations: testMigrations[:1]}
+	var out bytes.Buffer
+	err := m.DryRun(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-- schema_version table\n" + strings.TrimSpace(versionTableSQL) +
+		"\n\n-- version 1: create foo\nCREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL);\n\n"
+	if out.String() != want {
+		t.Errorf("unexpected dry run output:\n%s", out.String())
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM sqlite_master`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("dry run created %d tables", count)
+	}
+}
+
+func TestMigratorFailedMigration(t *testing.T) {
+	db := openDB(t)
+
+	m := Migrator{DB: db, Migrations: []Migration{
+		testMigrations[0],
+		{2, "broken", []string{`INSERT INTO foo (name) VALUES ('a')`, `INSERT INTO missing VALUES (1)`}},
+	}}
+	err := m.Up()
+	if err == nil || !strings.Contains(err.Error(), "version 2 (broken)") {
+		t.Fatalf("got error %v, want error of version 2", err)
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("got version %d after failed migration, want 1", version)
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM foo`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("failed migration is not rolled back")
+	}
+}
+
+func TestMigratorVersions(t *testing.T) {
+	db := openDB(t)
+
+	err := Migrator{DB: db, Migrations: testMigrations}.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Migrator{DB: db, Migrations: testMigrations[:2]}.Up()
+	if err == nil {
+		t.Error("no error for database newer than migrations")
+	}
+
+	err = Migrator{DB: db, Migrations: []Migration{testMigrations[1], testMigrations[0]}}.Up()
+	if err == nil {
+		t.Error("no error for unordered migrations")
+	}
+}
@@ -0,0 +1,878 @@
+// Package modeltest provides conformance test suite for depechebot Model
+// implementations, so that every backend behaves the same way.
+//
+// Usage in the backend's tests:
+//
+//	func TestModel(t *testing.T) {
+//		modeltest.Run(t, func(t *testing.T) dbot.Model {
+//			return mybackend.NewModel(freshEmptyDatabase(t))
+//		})
+//	}
+package modeltest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	dbot "github.com/depechebot/depechebot"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Factory returns new empty Model, not initialized yet.
+// Resources should be released with t.Cleanup.
+type Factory func(t *testing.T) dbot.Model
+
+// Run runs the whole suite, every test with a new Model.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, dbot.Model)
+	}{
+		{"InitEmpty", testInitEmpty},
+		{"InitExisting", testInitExisting},
+		{"NotFound", testNotFound},
+		{"InsertPrimaryID", testInsertPrimaryID},
+		{"UniqueChatID", testUniqueChatID},
+		{"SaveUpdatesExisting", testSaveUpdatesExisting},
+		{"UpdateMissing", testUpdateMissing},
+		{"Delete", testDelete},
+		{"Unicode", testUnicode},
+		{"ParamsRoundTrip", testParamsRoundTrip},
+		{"DataRoundTrip", testDataRoundTrip},
+		{"ChatsByQuery", testChatsByQuery},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"SaveUser", testSaveUser},
+		{"Members", testMembers},
+		{"MemberChat", testMemberChat},
+		{"MigrateChat", testMigrateChat},
+		{"Broadcasts", testBroadcasts},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			m := factory(t)
+			_, err := m.Init()
+			if err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			test.test(t, m)
+		})
+	}
+}
+
+// newChat returns chat as the bot creates it.
+func newChat(chatID dbot.ChatID) *dbot.Chat {
+	// database precision could be lower than nanoseconds
+	now := time.Now().UTC().Truncate(time.Second)
+	return &dbot.Chat{
+		ChatID:    chatID,
+		Type:      "private",
+		UserID:    int(chatID),
+		UserName:  "username",
+		FirstName: "First",
+		LastName:  "Last",
+		OpenTime:  now,
+		LastTime:  now,
+		State:     dbot.StartState,
+		Params:    dbot.Params{},
+		Data:      dbot.Data{},
+	}
+}
+
+func insert(t *testing.T, m dbot.Model, chats ...*dbot.Chat) {
+	t.Helper()
+	for _, c := range chats {
+		err := m.Insert(c)
+		if err != nil {
+			t.Fatalf("Insert(%v): %v", c.ChatID, err)
+		}
+	}
+}
+
+func get(t *testing.T, m dbot.Model, chatID dbot.ChatID) *dbot.Chat {
+	t.Helper()
+	c, err := m.ChatByChatID(chatID)
+	if err != nil {
+		t.Fatalf("ChatByChatID(%v): %v", chatID, err)
+	}
+	return c
+}
+
+func testInitEmpty(t *testing.T, m dbot.Model) {
+	chatIDs, err := m.Init()
+	if err != nil {
+		t.Fatalf("second Init: %v", err)
+	}
+	if len(chatIDs) != 0 {
+		t.Errorf("Init of empty model returned %v", chatIDs)
+	}
+}
+
+func testInitExisting(t *testing.T, m dbot.Model) {
+	insert(t, m, newChat(1), newChat(-2), newChat(3000000000000))
+
+	chatIDs, err := m.Init()
+	if err != nil {
+		t.Fatalf("second Init: %v", err)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	if fmt.Sprint(chatIDs) != "[-2 1 3000000000000]" {
+		t.Errorf("Init returned %v, want [-2 1 3000000000000]", chatIDs)
+	}
+}
+
+func testNotFound(t *testing.T, m dbot.Model) {
+	c := newChat(1)
+	insert(t, m, c)
+
+	chat, err := m.ChatByChatID(2)
+	if err == nil || chat != nil {
+		t.Errorf("ChatByChatID of missing chat returned %v, %v", chat, err)
+	}
+	chat, err = m.ChatByPrimaryID(c.PrimaryID + 1)
+	if err == nil || chat != nil {
+		t.Errorf("ChatByPrimaryID of missing chat returned %v, %v", chat, err)
+	}
+
+	exists, err := m.Exists(newChat(2))
+	if err != nil {
+		t.Fatalf("Exists: %v", err)
+	}
+	if exists {
+		t.Error("Exists of missing chat returned true")
+	}
+}
+
+func testInsertPrimaryID(t *testing.T, m dbot.Model) {
+	c1, c2 := newChat(1), newChat(2)
+	insert(t, m, c1, c2)
+
+	if c1.PrimaryID == c2.PrimaryID {
+		t.Fatalf("Insert set the same PrimaryID %v", c1.PrimaryID)
+	}
+
+	chat, err := m.ChatByPrimaryID(c2.PrimaryID)
+	if err != nil {
+		t.Fatalf("ChatByPrimaryID: %v", err)
+	}
+	if chat.ChatID != 2 {
+		t.Errorf("ChatByPrimaryID(%v) returned chat %v, want 2", c2.PrimaryID, chat.ChatID)
+	}
+}
+
+func testUniqueChatID(t *testing.T, m dbot.Model) {
+	insert(t, m, newChat(1))
+
+	err := m.Insert(newChat(1))
+	if err == nil {
+		t.Error("Insert of existing chat returned no error")
+	}
+
+	chatIDs, err := m.Init()
+	if err != nil {
+		t.Fatalf("second Init: %v", err)
+	}
+	if len(chatIDs) != 1 {
+		t.Errorf("model has %v chats after duplicate Insert, want 1", len(chatIDs))
+	}
+}
+
+func testSaveUpdatesExisting(t *testing.T, m dbot.Model) {
+	c := newChat(1)
+	err := m.Save(c)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	c.Abandoned = true
+	c.State = dbot.NewState("NEXT")
+	err = m.Save(c)
+	if err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	chat := get(t, m, 1)
+	if !chat.Abandoned || chat.State.Name != "NEXT" {
+		t.Errorf("Save didn't update chat: %+v", chat)
+	}
+	if chat.PrimaryID != c.PrimaryID {
+		t.Errorf("Save changed PrimaryID from %v to %v", c.PrimaryID, chat.PrimaryID)
+	}
+}
+
+func testUpdateMissing(t *testing.T, m dbot.Model) {
+	err := m.Update(newChat(1))
+	if err != dbot.ErrNoChat {
+		t.Errorf("Update of missing chat returned %v, want ErrNoChat", err)
+	}
+
+	exists, err := m.Exists(newChat(1))
+	if err != nil {
+		t.Fatalf("Exists: %v", err)
+	}
+	if exists {
+		t.Error("Update inserted missing chat")
+	}
+}
+
+func testDelete(t *testing.T, m dbot.Model) {
+	c1, c2 := newChat(1), newChat(2)
+	insert(t, m, c1, c2)
+
+	err := m.Delete(c1)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	err = m.Delete(c1)
+	if err != nil {
+		t.Errorf("Delete of missing chat: %v", err)
+	}
+
+	_, err = m.ChatByChatID(1)
+	if err == nil {
+		t.Error("deleted chat retrieved with no error")
+	}
+	get(t, m, 2)
+}
+
+func testUnicode(t *testing.T, m dbot.Model) {
+	c := newChat(1)
+	c.UserName = "юзер"
+	c.FirstName = "Вася 🙂"
+	c.LastName = "O'Brien \"Jr\" 李"
+	insert(t, m, c)
+
+	chat := get(t, m, 1)
+	if chat.UserName != c.UserName || chat.FirstName != c.FirstName || chat.LastName != c.LastName {
+		t.Errorf("got names %q %q %q, want %q %q %q",
+			chat.UserName, chat.FirstName, chat.LastName, c.UserName, c.FirstName, c.LastName)
+	}
+}
+
+func testParamsRoundTrip(t *testing.T, m dbot.Model) {
+	c := newChat(1)
+	c.State = dbot.NewState("TEST").WithParam("step", "2").WithParam("имя", "значение")
+	c.Params = dbot.Params{
+		"empty":   "",
+		"quotes":  `"'\`,
+		"newline": "a\nb",
+		"unicode": "🙂",
+	}
+	c.Type = "supergroup"
+	c.Abandoned = true
+	insert(t, m, c)
+
+	chat := get(t, m, 1)
+	if chat.State.String() != c.State.String() {
+		t.Errorf("got state %v, want %v", chat.State, c.State)
+	}
+	if fmt.Sprint(chat.Params) != fmt.Sprint(c.Params) {
+		t.Errorf("got params %v, want %v", chat.Params, c.Params)
+	}
+	if chat.Type != c.Type || chat.Abandoned != c.Abandoned || chat.UserID != c.UserID {
+		t.Errorf("got chat %+v, want %+v", chat, c)
+	}
+	if !chat.OpenTime.Equal(c.OpenTime) || !chat.LastTime.Equal(c.LastTime) {
+		t.Errorf("got times %v %v, want %v %v", chat.OpenTime, chat.LastTime, c.OpenTime, c.LastTime)
+	}
+
+	chat.Params.Set("new", "value")
+	err := m.Update(chat)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if get(t, m, 1).Params.Get("new") != "value" {
+		t.Error("Update didn't save params")
+	}
+}
+
+func testDataRoundTrip(t *testing.T, m dbot.Model) {
+	type item struct {
+		Name  string            `json:"name"`
+		Price float64           `json:"price"`
+		Tags  map[string]string `json:"tags"`
+	}
+	cart := []item{{"tea", 2.5, map[string]string{"color": "green"}}, {"чай", 3, nil}}
+
+	c := newChat(1)
+	c.Data.Set("cart", cart)
+	c.Data.Set("count", 42)
+	c.Data.Set("name", "Dave")
+	insert(t, m, c)
+
+	chat := get(t, m, 1)
+	var gotCart []item
+	ok, err := chat.Data.Get("cart", &gotCart)
+	if !ok || err != nil {
+		t.Fatalf("Get(cart) returned %v, %v", ok, err)
+	}
+	if fmt.Sprint(gotCart) != fmt.Sprint(cart) {
+		t.Errorf("got cart %v, want %v", gotCart, cart)
+	}
+	if chat.Data.GetInt("count") != 42 || chat.Data.GetString("name") != "Dave" {
+		t.Errorf("got data %v", chat.Data.Keys())
+	}
+
+	chat.Data.Delete("cart")
+	chat.Data.Set("count", 43)
+	err = m.Update(chat)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	chat = get(t, m, 1)
+	if chat.Data.Has("cart") || chat.Data.GetInt("count") != 43 {
+		t.Errorf("Update didn't save data, got keys %v", chat.Data.Keys())
+	}
+}
+
+func testChatsByQuery(t *testing.T, m dbot.Model) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	c1, c2, c3, c4 := newChat(1), newChat(2), newChat(-3), newChat(4)
+	c1.Params = dbot.Params{"group": "alpha"}
+	c1.State = dbot.NewState("MAIN")
+	c2.Params = dbot.Params{"group": "beta", "vip": "yes"}
+	c2.State = dbot.NewState("MAIN").WithParam("step", "2").WithParam("menu", "settings")
+	c2.LastTime = now.Add(-48 * time.Hour)
+	c3.Params = dbot.Params{"percent": "100%", "alpha": "group"}
+	c3.Type = "group"
+	c3.LastTime = now.In(time.FixedZone("UTC+5", 5*60*60)).Add(-2 * time.Hour)
+	c4.Params = dbot.Params{`"quoted".key`: "a_pha"}
+	c4.Abandoned = true
+	insert(t, m, c1, c2, c3, c4)
+
+	tests := []struct {
+		name  string
+		query dbot.Query
+		want  string
+	}{
+		{"all", dbot.NewQuery(), "[1 2 -3 4]"},
+		{"param", dbot.NewQuery().WithParam("group", "alpha"), "[1]"},
+		{"param value is not key", dbot.NewQuery().WithParam("alpha", "group"), "[-3]"},
+		{"param is exact", dbot.NewQuery().WithParam("group", "alph"), "[]"},
+		{"param is case sensitive", dbot.NewQuery().WithParam("group", "ALPHA"), "[]"},
+		{"param without wildcards", dbot.NewQuery().WithParam("percent", "100_"), "[]"},
+		{"param with special key", dbot.NewQuery().WithParam(`"quoted".key`, "a_pha"), "[4]"},
+		{"params", dbot.NewQuery().WithParam("group", "beta").WithParam("vip", "yes"), "[2]"},
+		{"param key", dbot.NewQuery().WithParamKey("group"), "[1 2]"},
+		{"param keys", dbot.NewQuery().WithParamKey("group").WithParamKey("vip"), "[2]"},
+		{"missing param key", dbot.NewQuery().WithParamKey("missing"), "[]"},
+		{"state param", dbot.NewQuery().WithStateParam("step", "2"), "[2]"},
+		{"state params", dbot.NewQuery().WithStateParam("step", "2").WithStateParam("menu", "main"), "[]"},
+		{"state param is not chat param", dbot.NewQuery().WithStateParam("group", "alpha"), "[]"},
+		{"state", dbot.NewQuery().InState("MAIN"), "[1 2]"},
+		{"states", dbot.NewQuery().InState("MAIN", dbot.StartState.Name), "[1 2 -3 4]"},
+		{"type", dbot.NewQuery().OfType("group", "supergroup"), "[-3]"},
+		{"abandoned", dbot.NewQuery().WithAbandoned(true), "[4]"},
+		{"not abandoned", dbot.NewQuery().WithAbandoned(false), "[1 2 -3]"},
+		{"active", dbot.NewQuery().ActiveSince(now.Add(-24 * time.Hour)), "[1 -3 4]"},
+		{"active with zone", dbot.NewQuery().ActiveSince(now.Add(-time.Hour)), "[1 4]"},
+		{"inactive", dbot.NewQuery().InactiveSince(now.Add(-time.Hour)), "[2 -3]"},
+		{"combined", dbot.NewQuery().InState("MAIN").WithParamKey("vip").InactiveSince(now), "[2]"},
+	}
+
+	for _, test := range tests {
+		chats, err := m.ChatsByQuery(test.query)
+		if err != nil {
+			t.Fatalf("%v: ChatsByQuery(%+v): %v", test.name, test.query, err)
+		}
+		if chats == nil {
+			t.Errorf("%v: ChatsByQuery returned nil instead of empty slice", test.name)
+		}
+
+		var chatIDs []int
+		for _, c := range chats {
+			chatIDs = append(chatIDs, int(c.ChatID))
+			if !test.query.Match(c) {
+				t.Errorf("%v: returned chat %v doesn't match the query", test.name, c.ChatID)
+			}
+		}
+		if got := fmt.Sprint(chatIDs); got != test.want {
+			t.Errorf("%v: ChatsByQuery returned %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, m dbot.Model) {
+	const (
+		chatsNum   = 10
+		updatesNum = 20
+	)
+
+	for i := 1; i <= chatsNum; i++ {
+		insert(t, m, newChat(dbot.ChatID(i)))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, chatsNum*updatesNum)
+	for i := 1; i <= chatsNum; i++ {
+		wg.Add(1)
+		go func(chatID dbot.ChatID) {
+			defer wg.Done()
+			c, err := m.ChatByChatID(chatID)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 1; j <= updatesNum; j++ {
+				c.Params = dbot.Params{"counter": fmt.Sprint(j)}
+				c.State = dbot.NewState(fmt.Sprint("STATE", j))
+				err := m.Save(c)
+				if err != nil {
+					errs <- err
+				}
+				_, err = m.ChatByChatID(chatID)
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(dbot.ChatID(i))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent update: %v", err)
+	}
+
+	for i := 1; i <= chatsNum; i++ {
+		chat := get(t, m, dbot.ChatID(i))
+		if chat.Params.Get("counter") != fmt.Sprint(updatesNum) ||
+			chat.State.Name != dbot.StateName(fmt.Sprint("STATE", updatesNum)) {
+			t.Errorf("chat %v has params %v and state %v after concurrent updates",
+				i, chat.Params, chat.State)
+		}
+	}
+}
+
+func newUser(userID int) *dbot.User {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &dbot.User{
+		UserID:    userID,
+		UserName:  "username",
+		FirstName: "First",
+		LastName:  "Last",
+		OpenTime:  now,
+		LastTime:  now,
+	}
+}
+
+func testSaveUser(t *testing.T, m dbot.Model) {
+	_, err := m.UserByUserID(1)
+	if err == nil {
+		t.Error("UserByUserID of missing user returned no error")
+	}
+
+	u := newUser(1)
+	u.Params = dbot.Params{"lang": "ru"}
+	err = m.SaveUser(u)
+	if err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	u2 := newUser(2)
+	err = m.SaveUser(u2)
+	if err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	if u.PrimaryID == 0 || u.PrimaryID == u2.PrimaryID {
+		t.Errorf("SaveUser set PrimaryIDs %v and %v", u.PrimaryID, u2.PrimaryID)
+	}
+	if user, err := m.UserByUserID(2); err != nil || user.Params == nil {
+		t.Errorf("user saved with nil Params is retrieved as %+v, %v", user, err)
+	}
+
+	// profile update without params
+	profile := newUser(1)
+	profile.FirstName = "Новое имя"
+	profile.OpenTime = u.OpenTime.Add(time.Hour)
+	profile.LastTime = u.LastTime.Add(time.Hour)
+	err = m.SaveUser(profile)
+	if err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	if profile.PrimaryID != u.PrimaryID {
+		t.Errorf("SaveUser of existing user set PrimaryID %v, want %v", profile.PrimaryID, u.PrimaryID)
+	}
+
+	user, err := m.UserByUserID(1)
+	if err != nil {
+		t.Fatalf("UserByUserID: %v", err)
+	}
+	if user.FirstName != profile.FirstName || !user.LastTime.Equal(profile.LastTime) {
+		t.Errorf("SaveUser didn't update profile: %+v", user)
+	}
+	if !user.OpenTime.Equal(u.OpenTime) {
+		t.Errorf("SaveUser changed open time from %v to %v", u.OpenTime, user.OpenTime)
+	}
+	if user.Params.Get("lang") != "ru" {
+		t.Errorf("SaveUser with nil params dropped params: %v", user.Params)
+	}
+
+	user.Params = dbot.Params{}
+	err = m.SaveUser(user)
+	if err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	user, err = m.UserByUserID(1)
+	if err != nil {
+		t.Fatalf("UserByUserID: %v", err)
+	}
+	if len(user.Params) != 0 {
+		t.Errorf("SaveUser didn't clear params: %v", user.Params)
+	}
+}
+
+func testMembers(t *testing.T, m dbot.Model) {
+	group, other, private := newChat(-1), newChat(-2), newChat(10)
+	insert(t, m, group, other, private)
+	for _, userID := range []int{10, 20, 30} {
+		err := m.SaveUser(newUser(userID))
+		if err != nil {
+			t.Fatalf("SaveUser: %v", err)
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, member := range []struct {
+		chatID dbot.ChatID
+		userID int
+	}{{-1, 30}, {-1, 10}, {-1, 40}, {-2, 10}, {10, 10}, {-1, 10}} {
+		err := m.AddMember(member.chatID, member.userID, now)
+		if err != nil {
+			t.Fatalf("AddMember(%v, %v): %v", member.chatID, member.userID, err)
+		}
+	}
+
+	usersOf := func(chatID dbot.ChatID) string {
+		users, err := m.UsersByChatID(chatID)
+		if err != nil {
+			t.Fatalf("UsersByChatID(%v): %v", chatID, err)
+		}
+		var userIDs []int
+		for _, u := range users {
+			userIDs = append(userIDs, u.UserID)
+		}
+		return fmt.Sprint(userIDs)
+	}
+	chatsOf := func(userID int) string {
+		chats, err := m.ChatsByUserID(userID)
+		if err != nil {
+			t.Fatalf("ChatsByUserID(%v): %v", userID, err)
+		}
+		var chatIDs []dbot.ChatID
+		for _, c := range chats {
+			chatIDs = append(chatIDs, c.ChatID)
+		}
+		return fmt.Sprint(chatIDs)
+	}
+
+	// user 40 is not saved
+	if got := usersOf(-1); got != "[10 30]" {
+		t.Errorf("UsersByChatID(-1) returned %v, want [10 30]", got)
+	}
+	if got := chatsOf(10); got != "[-1 -2 10]" {
+		t.Errorf("ChatsByUserID(10) returned %v, want [-1 -2 10]", got)
+	}
+	if got := chatsOf(20); got != "[]" {
+		t.Errorf("ChatsByUserID(20) returned %v, want []", got)
+	}
+
+	err := m.RemoveMember(-1, 30)
+	if err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if got := usersOf(-1); got != "[10]" {
+		t.Errorf("UsersByChatID(-1) after RemoveMember returned %v, want [10]", got)
+	}
+
+	err = m.Delete(other)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	insert(t, m, newChat(-2))
+	if got := usersOf(-2); got != "[]" {
+		t.Errorf("recreated chat has members %v", got)
+	}
+
+	err = m.DeleteUser(newUser(10))
+	if err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	err = m.SaveUser(newUser(10))
+	if err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	if got := chatsOf(10); got != "[]" {
+		t.Errorf("recreated user is member of %v", got)
+	}
+}
+
+func testMemberChat(t *testing.T, m dbot.Model) {
+	group := newChat(-1)
+	group.Type = "supergroup"
+	group.State = dbot.NewState("GROUP")
+	group.Params = dbot.Params{"scope": "group"}
+	insert(t, m, group)
+	u := newUser(10)
+	u.FirstName = "Member"
+	err := m.SaveUser(u)
+	if err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+
+	// membership without conversation
+	err = m.AddMember(-1, 10, time.Now())
+	if err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	_, err = m.MemberChat(-1, 10)
+	if err != dbot.ErrNoMemberChat {
+		t.Errorf("MemberChat without conversation returned %v, want ErrNoMemberChat", err)
+	}
+
+	member := newChat(-1)
+	member.UserID = 10
+	member.State = dbot.NewState("FORM").WithParam("step", "1")
+	member.Params = dbot.Params{"scope": "member"}
+	member.Data.Set("answers", []string{"yes"})
+	err = m.SaveMemberChat(member)
+	if err != nil {
+		t.Fatalf("SaveMemberChat: %v", err)
+	}
+
+	chat, err := m.MemberChat(-1, 10)
+	if err != nil {
+		t.Fatalf("MemberChat: %v", err)
+	}
+	var answers []string
+	chat.Data.Get("answers", &answers)
+	if chat.ChatID != -1 || chat.UserID != 10 || chat.Type != "supergroup" || chat.FirstName != "Member" ||
+		chat.State.String() != member.State.String() || chat.Params.Get("scope") != "member" ||
+		fmt.Sprint(answers) != "[yes]" || !chat.LastTime.Equal(member.LastTime) {
+		t.Errorf("MemberChat returned %+v", chat)
+	}
+
+	// conversation of user without saved profile
+	other := newChat(-1)
+	other.UserID = 20
+	err = m.SaveMemberChat(other)
+	if err != nil {
+		t.Fatalf("SaveMemberChat: %v", err)
+	}
+	chat, err = m.MemberChat(-1, 20)
+	if err != nil {
+		t.Fatalf("MemberChat: %v", err)
+	}
+	if chat.FirstName != "" || chat.State.Name != dbot.StartState.Name {
+		t.Errorf("MemberChat of user without profile returned %+v", chat)
+	}
+
+	g := get(t, m, -1)
+	if g.State.Name != "GROUP" || g.Params.Get("scope") != "group" {
+		t.Errorf("SaveMemberChat changed group chat: %+v", g)
+	}
+	if _, err := m.MemberChat(-2, 10); err == nil {
+		t.Error("MemberChat of missing chat returned no error")
+	}
+}
+
+func testMigrateChat(t *testing.T, m dbot.Model) {
+	group, other := newChat(-1), newChat(-2)
+	group.Type = "group"
+	group.State = dbot.NewState("GROUP")
+	group.Params = dbot.Params{"key": "value"}
+	insert(t, m, group, other)
+
+	member := newChat(-1)
+	member.UserID = 10
+	member.State = dbot.NewState("FORM")
+	err := m.SaveMemberChat(member)
+	if err != nil {
+		t.Fatalf("SaveMemberChat: %v", err)
+	}
+	err = m.SaveUser(newUser(10))
+	if err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+
+	err = m.MigrateChat(-1, -2)
+	if err == nil {
+		t.Error("MigrateChat to existing chat returned no error")
+	}
+	get(t, m, -1)
+
+	err = m.MigrateChat(-1, -1001)
+	if err != nil {
+		t.Fatalf("MigrateChat: %v", err)
+	}
+
+	if _, err := m.ChatByChatID(-1); err == nil {
+		t.Error("old chat is retrieved after migration")
+	}
+	chat := get(t, m, -1001)
+	if chat.PrimaryID != group.PrimaryID || chat.Type != "supergroup" ||
+		chat.State.Name != "GROUP" || chat.Params.Get("key") != "value" {
+		t.Errorf("migrated chat is %+v", chat)
+	}
+
+	chat, err = m.MemberChat(-1001, 10)
+	if err != nil {
+		t.Fatalf("MemberChat of migrated chat: %v", err)
+	}
+	if chat.State.Name != "FORM" {
+		t.Errorf("migrated member chat is in state %v, want FORM", chat.State.Name)
+	}
+	users, err := m.UsersByChatID(-1)
+	if err != nil {
+		t.Fatalf("UsersByChatID: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("old chat has members %v after migration", users)
+	}
+}
+
+func testBroadcasts(t *testing.T, model dbot.Model) {
+	m, ok := model.(dbot.BroadcastStore)
+	if !ok {
+		t.Skip("model doesn't implement BroadcastStore")
+	}
+
+	_, err := m.BroadcastByID(1)
+	if err == nil {
+		t.Error("BroadcastByID of missing broadcast returned no error")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	msg := tgbotapi.NewMessage(0, "Новости")
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	bc := &dbot.Broadcast{
+		Message:    dbot.BroadcastMessage{Chattable: msg},
+		Status:     dbot.BroadcastRunning,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	err = m.InsertBroadcast(bc, []dbot.ChatID{30, -10, 20})
+	if err != nil {
+		t.Fatalf("InsertBroadcast: %v", err)
+	}
+	photo := &dbot.Broadcast{
+		Message:    dbot.BroadcastMessage{Chattable: tgbotapi.NewPhotoShare(0, "file-id")},
+		Status:     dbot.BroadcastRunning,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	err = m.InsertBroadcast(photo, []dbot.ChatID{30})
+	if err != nil {
+		t.Fatalf("InsertBroadcast: %v", err)
+	}
+	if bc.ID == 0 || bc.ID == photo.ID {
+		t.Errorf("InsertBroadcast set IDs %v and %v", bc.ID, photo.ID)
+	}
+
+	invalid := &dbot.Broadcast{
+		Message:    dbot.BroadcastMessage{Chattable: tgbotapi.NewPhotoUpload(0, tgbotapi.FileBytes{Name: "photo"})},
+		Status:     dbot.BroadcastRunning,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	err = m.InsertBroadcast(invalid, []dbot.ChatID{30})
+	if err == nil {
+		t.Error("InsertBroadcast of uploaded file returned no error")
+	}
+
+	got, err := m.BroadcastByID(bc.ID)
+	if err != nil {
+		t.Fatalf("BroadcastByID: %v", err)
+	}
+	gotMsg, ok := got.Message.Chattable.(tgbotapi.MessageConfig)
+	if !ok || gotMsg.Text != msg.Text || gotMsg.ParseMode != msg.ParseMode ||
+		got.Status != dbot.BroadcastRunning || !got.CreateTime.Equal(now) {
+		t.Errorf("BroadcastByID returned %+v", got)
+	}
+	got, err = m.BroadcastByID(photo.ID)
+	if err != nil {
+		t.Fatalf("BroadcastByID: %v", err)
+	}
+	if gotPhoto, ok := got.Message.Chattable.(tgbotapi.PhotoConfig); !ok || gotPhoto.FileID != "file-id" {
+		t.Errorf("BroadcastByID returned photo %+v", got.Message)
+	}
+
+	recipients, err := m.RecipientsByBroadcastID(bc.ID)
+	if err != nil {
+		t.Fatalf("RecipientsByBroadcastID: %v", err)
+	}
+	want := []dbot.Recipient{
+		{ChatID: 30, Status: dbot.RecipientPending},
+		{ChatID: -10, Status: dbot.RecipientPending},
+		{ChatID: 20, Status: dbot.RecipientPending},
+	}
+	if !equalRecipients(recipients, want) {
+		t.Errorf("RecipientsByBroadcastID = %+v, want %+v", recipients, want)
+	}
+
+	want[0] = dbot.Recipient{ChatID: 30, Status: dbot.RecipientSent, Time: now}
+	want[1] = dbot.Recipient{ChatID: -10, Status: dbot.RecipientBlocked, Error: "Forbidden", Time: now, Attempts: 1}
+	want[2] = dbot.Recipient{ChatID: 20, Status: dbot.RecipientPending, Error: "Too Many Requests", Time: now, Attempts: 2}
+	for _, r := range want {
+		err = m.UpdateRecipient(bc.ID, r)
+		if err != nil {
+			t.Fatalf("UpdateRecipient: %v", err)
+		}
+	}
+	recipients, err = m.RecipientsByBroadcastID(bc.ID)
+	if err != nil {
+		t.Fatalf("RecipientsByBroadcastID: %v", err)
+	}
+	if !equalRecipients(recipients, want) {
+		t.Errorf("RecipientsByBroadcastID after update = %+v, want %+v", recipients, want)
+	}
+
+	photo.Status = dbot.BroadcastDone
+	photo.UpdateTime = now.Add(time.Minute)
+	err = m.UpdateBroadcast(photo)
+	if err != nil {
+		t.Fatalf("UpdateBroadcast: %v", err)
+	}
+	for status, ids := range map[dbot.BroadcastStatus][]int{
+		dbot.BroadcastRunning:  {bc.ID},
+		dbot.BroadcastDone:     {photo.ID},
+		dbot.BroadcastCanceled: {},
+	} {
+		bcs, err := m.BroadcastsByStatus(status)
+		if err != nil {
+			t.Fatalf("BroadcastsByStatus: %v", err)
+		}
+		gotIDs := []int{}
+		for _, bc := range bcs {
+			gotIDs = append(gotIDs, bc.ID)
+		}
+		if fmt.Sprint(gotIDs) != fmt.Sprint(ids) {
+			t.Errorf("BroadcastsByStatus(%v) = %v, want %v", status, gotIDs, ids)
+		}
+	}
+	got, err = m.BroadcastByID(photo.ID)
+	if err != nil {
+		t.Fatalf("BroadcastByID: %v", err)
+	}
+	if !got.UpdateTime.Equal(photo.UpdateTime) {
+		t.Errorf("UpdateBroadcast saved update time %v, want %v", got.UpdateTime, photo.UpdateTime)
+	}
+}
+
+func equalRecipients(got, want []dbot.Recipient) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].ChatID != want[i].ChatID || got[i].Status != want[i].Status ||
+			got[i].Error != want[i].Error || !got[i].Time.Equal(want[i].Time) ||
+			got[i].Attempts != want[i].Attempts {
+			return false
+		}
+	}
+	return true
+}
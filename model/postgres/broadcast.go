@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	dbot "github.com/depechebot/depechebot"
+)
+
+// InsertBroadcast inserts broadcast with pending recipients and sets bc.ID.
+func (m Model) InsertBroadcast(bc *dbot.Broadcast, chatIDs []dbot.ChatID) error {
+	var err error
+
+	message, err := json.Marshal(bc.Message)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const sqlstr = `INSERT INTO broadcast (` +
+		`message, status, create_time, update_time` +
+		`) VALUES (` +
+		`$1, $2, $3, $4` +
+		`) RETURNING id`
+
+	var id int
+	err = tx.QueryRow(sqlstr, string(message), bc.Status, bc.CreateTime, bc.UpdateTime).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	const recipientSQL = `INSERT INTO broadcast_recipient (` +
+		`broadcast_id, position, chat_id, status` +
+		`) VALUES (` +
+		`$1, $2, $3, $4` +
+		`)`
+
+	stmt, err := tx.Prepare(recipientSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, chatID := range chatIDs {
+		_, err = stmt.Exec(id, i, chatID, dbot.RecipientPending)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	bc.ID = id
+
+	return nil
+}
+
+// UpdateBroadcast updates Status and UpdateTime of broadcast.
+func (m Model) UpdateBroadcast(bc *dbot.Broadcast) error {
+	var err error
+
+	const sqlstr = `UPDATE broadcast SET status = $1, update_time = $2 WHERE id = $3`
+
+	_, err = m.db.Exec(sqlstr, bc.Status, bc.UpdateTime, bc.ID)
+	return err
+}
+
+// BroadcastByID retrieves a broadcast by id.
+func (m Model) BroadcastByID(id int) (*dbot.Broadcast, error) {
+	const sqlstr = `SELECT ` +
+		`id, message, status, create_time, update_time ` +
+		`FROM broadcast ` +
+		`WHERE id = $1`
+
+	q, err := m.db.Query(sqlstr, id)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	bcs, err := scanBroadcasts(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(bcs) == 0 {
+		return nil, errors.New("broadcast not found")
+	}
+
+	return bcs[0], nil
+}
+
+// BroadcastsByStatus retrieves broadcasts with status.
+func (m Model) BroadcastsByStatus(status dbot.BroadcastStatus) ([]*dbot.Broadcast, error) {
+	const sqlstr = `SELECT ` +
+		`id, message, status, create_time, update_time ` +
+		`FROM broadcast ` +
+		`WHERE status = $1 ` +
+		`ORDER BY id`
+
+	q, err := m.db.Query(sqlstr, status)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	return scanBroadcasts(q)
+}
+
+// UpdateRecipient updates status of broadcast's recipient r.ChatID.
+func (m Model) UpdateRecipient(id int, r dbot.Recipient) error {
+	var err error
+
+	const sqlstr = `UPDATE broadcast_recipient SET ` +
+		`status = $1, error = $2, attempt_time = $3, attempts = $4 ` +
+		`WHERE broadcast_id = $5 AND chat_id = $6`
+
+	var t interface{}
+	if !r.Time.IsZero() {
+		t = r.Time
+	}
+
+	_, err = m.db.Exec(sqlstr, r.Status, r.Error, t, r.Attempts, id, r.ChatID)
+	return err
+}
+
+// RecipientsByBroadcastID retrieves recipients of broadcast.
+func (m Model) RecipientsByBroadcastID(id int) ([]dbot.Recipient, error) {
+	var err error
+
+	const sqlstr = `SELECT ` +
+		`chat_id, status, error, attempt_time, attempts ` +
+		`FROM broadcast_recipient ` +
+		`WHERE broadcast_id = $1 ` +
+		`ORDER BY position`
+
+	q, err := m.db.Query(sqlstr, id)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	recipients := []dbot.Recipient{}
+	for q.Next() {
+		r := dbot.Recipient{}
+		var t sql.NullTime
+
+		err = q.Scan(&r.ChatID, &r.Status, &r.Error, &t, &r.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		r.Time = t.Time
+
+		recipients = append(recipients, r)
+	}
+
+	return recipients, q.Err()
+}
+
+// scanBroadcasts reads all broadcasts from q.
+func scanBroadcasts(q *sql.Rows) ([]*dbot.Broadcast, error) {
+	var err error
+	var message string
+
+	bcs := []*dbot.Broadcast{}
+	for q.Next() {
+		bc := dbot.Broadcast{}
+
+		err = q.Scan(&bc.ID, &message, &bc.Status, &bc.CreateTime, &bc.UpdateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(message), &bc.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		bcs = append(bcs, &bc)
+	}
+
+	return bcs, nil
+}
@@ -0,0 +1,143 @@
+package postgres
+
+import "github.com/depechebot/depechebot/model/migrate"
+
+// migrations of chat table, ordered by version.
+// Never change applied migrations, add new ones instead.
+var migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create chat table",
+		Statements: []string{`CREATE TABLE IF NOT EXISTS chat (
+  primary_id SERIAL PRIMARY KEY,
+  chat_id BIGINT UNIQUE NOT NULL,
+  type TEXT NOT NULL,
+  abandoned BOOLEAN NOT NULL,
+  user_id INTEGER NOT NULL,
+  user_name TEXT NOT NULL DEFAULT '',
+  first_name TEXT NOT NULL,
+  last_name TEXT NOT NULL,
+  open_time TIMESTAMP NOT NULL,
+  last_time TIMESTAMP NOT NULL,
+  state TEXT NOT NULL,
+  params TEXT NOT NULL
+)`},
+	},
+	{
+		// formerly migrate.sql
+		Version: 2,
+		Name:    "fix state and params JSON",
+		Statements: []string{
+			`UPDATE chat SET state = replace(state, '"params":"{}"', '"params":{}')`,
+			`UPDATE chat SET params = replace(params, chr(10), ', ')`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "index chat query fields",
+		Statements: []string{
+			// TIMESTAMP dropped zone of written times, so last time ranges were off;
+			// existing values are taken in server's time zone
+			`ALTER TABLE chat ALTER COLUMN open_time TYPE TIMESTAMPTZ, ALTER COLUMN last_time TYPE TIMESTAMPTZ`,
+			`CREATE INDEX IF NOT EXISTS chat_state_name ON chat ((state::jsonb ->> 'name'))`,
+			`CREATE INDEX IF NOT EXISTS chat_params ON chat USING GIN ((params::jsonb))`,
+			`CREATE INDEX IF NOT EXISTS chat_type ON chat (type, abandoned)`,
+			`CREATE INDEX IF NOT EXISTS chat_last_time ON chat (last_time)`,
+		},
+	},
+	{
+		// in place: TEXT values are parsed as JSON, so invalid JSON fails the migration
+		Version: 4,
+		Name:    "store state and params as jsonb",
+		Statements: []string{
+			`DROP INDEX IF EXISTS chat_state_name`,
+			`DROP INDEX IF EXISTS chat_params`,
+			`ALTER TABLE chat ALTER COLUMN state TYPE JSONB USING state::jsonb, ALTER COLUMN params TYPE JSONB USING params::jsonb`,
+			`CREATE INDEX chat_state_name ON chat ((state ->> 'name'))`,
+			`CREATE INDEX chat_state ON chat USING GIN (state jsonb_path_ops)`,
+			`CREATE INDEX chat_params ON chat USING GIN (params)`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add chat data",
+		Statements: []string{
+			`ALTER TABLE chat ADD COLUMN data JSONB NOT NULL DEFAULT '{}'`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create user and chat member tables",
+		Statements: []string{
+			`CREATE TABLE tg_user (
+  primary_id SERIAL PRIMARY KEY,
+  user_id INTEGER UNIQUE NOT NULL,
+  user_name TEXT NOT NULL DEFAULT '',
+  first_name TEXT NOT NULL,
+  last_name TEXT NOT NULL,
+  open_time TIMESTAMPTZ NOT NULL,
+  last_time TIMESTAMPTZ NOT NULL,
+  params JSONB NOT NULL
+)`,
+			`CREATE TABLE chat_member (
+  chat_id BIGINT NOT NULL,
+  user_id INTEGER NOT NULL,
+  join_time TIMESTAMPTZ NOT NULL,
+  last_time TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (chat_id, user_id)
+)`,
+			`CREATE INDEX chat_member_user_id ON chat_member (user_id)`,
+		},
+	},
+	{
+		// NULL state means member has no own conversation
+		Version: 7,
+		Name:    "add chat member conversation",
+		Statements: []string{
+			`ALTER TABLE chat_member ADD COLUMN state JSONB`,
+			`ALTER TABLE chat_member ADD COLUMN params JSONB`,
+			`ALTER TABLE chat_member ADD COLUMN data JSONB`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create broadcast tables",
+		Statements: []string{
+			`CREATE TABLE broadcast (
+  id SERIAL PRIMARY KEY,
+  message JSONB NOT NULL,
+  status TEXT NOT NULL,
+  create_time TIMESTAMPTZ NOT NULL,
+  update_time TIMESTAMPTZ NOT NULL
+)`,
+			`CREATE INDEX broadcast_status ON broadcast (status)`,
+			`CREATE TABLE broadcast_recipient (
+  broadcast_id INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  chat_id BIGINT NOT NULL,
+  status TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  attempt_time TIMESTAMPTZ,
+  PRIMARY KEY (broadcast_id, chat_id)
+)`,
+		},
+	},
+	{
+		// chat tables created before migrations have INTEGER primary_id
+		// without default, SERIAL ones already have the sequence
+		Version: 9,
+		Name:    "add chat primary_id sequence",
+		Statements: []string{
+			`CREATE SEQUENCE IF NOT EXISTS chat_primary_id_seq OWNED BY chat.primary_id`,
+			`ALTER TABLE chat ALTER COLUMN primary_id SET DEFAULT nextval('chat_primary_id_seq')`,
+			`SELECT setval('chat_primary_id_seq', GREATEST(COALESCE((SELECT max(primary_id) FROM chat), 0) + 1, nextval('chat_primary_id_seq')), false)`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add broadcast recipient attempts",
+		Statements: []string{
+			`ALTER TABLE broadcast_recipient ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+}
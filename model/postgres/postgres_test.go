@@ -2,171 +2,144 @@ package postgres
 
 import (
 	"database/sql"
+	"os"
 	"testing"
-	"time"
 
 	dbot "github.com/depechebot/depechebot"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/depechebot/depechebot/model/migrate"
+	"github.com/depechebot/depechebot/model/modeltest"
+	_ "github.com/lib/pq"
 )
 
-func TestSqlite3ModelInit(t *testing.T) {
-	var m dbot.Model
+// Tests are run against database from DEPECHEBOT_POSTGRES_DSN
+// environment variable, e.g. "postgres://user@localhost/test?sslmode=disable".
+// All tables are dropped before every test!
+func TestPostgresModel(t *testing.T) {
+	modeltest.Run(t, func(t *testing.T) dbot.Model {
+		return NewModel(openDB(t))
+	})
+}
+
+func TestPostgresModelMigrateText(t *testing.T) {
+	db := openDB(t)
 
-	db, err := sql.Open("sqlite3", "./test.sqlite3")
+	// TEXT layout of schema version 3
+	err := migrate.Migrator{DB: db, Migrations: migrations[:3], Dollar: true}.Up()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-
-	m = NewModel(db)
-	chatIDs, err := m.Init()
+	_, err = db.Exec(`INSERT INTO chat (chat_id, type, abandoned, user_id, first_name, last_name, open_time, last_time, state, params) ` +
+		`VALUES (1, 'private', false, 1, '', '', now(), now(), '{"name":"MAIN","params":{"step":"2"}}', '{"foo":"bar"}')`)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	t.Logf("Number of loaded chats: %d", len(chatIDs))
-}
-
-func TestSqlite3ModelSaveRetrieveDelete(t *testing.T) {
-	var m dbot.Model
 
-	db, err := sql.Open("sqlite3", "./test2.sqlite3")
+	m := NewModel(db)
+	_, err = m.Init()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	m = NewModel(db)
-	chatIDs, err := m.Init()
+	chats, err := m.ChatsByQuery(dbot.NewQuery().WithParam("foo", "bar").WithStateParam("step", "2"))
 	if err != nil {
-		t.Error(err)
-	}
-	t.Logf("Number of loaded chats: %d", len(chatIDs))
-
-	params := dbot.Params(map[string]string{"foo": "bar", "noo": "bab"})
-	state := dbot.State{Name: "TEST", Params: params}
-	chat := &dbot.Chat{
-		ChatID:    88000111222,
-		Abandoned: false,
-		Type:      "private",
-		UserID:    123232,
-		UserName:  "username",
-		FirstName: "first_name",
-		LastName:  "Вава",
-		OpenTime:  time.Now(),
-		LastTime:  time.Now(),
-		State:     state,
-		Params:    params,
-	}
-
-	err = m.Save(chat)
-	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if len(chats) != 1 || chats[0].State.Name != "MAIN" {
+		t.Errorf("got chats %v after migration, want chat in MAIN", chats)
 	}
+}
 
-	chat.ChatID = -1324
-	exists, err := m.Exists(chat)
+func TestPostgresModelMigrateBaseline(t *testing.T) {
+	db := openDB(t)
+
+	// schema created by NewModel before migrations
+	_, err := db.Exec(`CREATE TABLE chat (
+  primary_id INTEGER NOT NULL PRIMARY KEY,
+  chat_id BIGINT UNIQUE NOT NULL,
+  type TEXT NOT NULL,
+  abandoned BOOLEAN NOT NULL,
+  user_id INTEGER NOT NULL,
+  user_name TEXT NOT NULL DEFAULT '',
+  first_name TEXT NOT NULL,
+  last_name TEXT NOT NULL,
+  open_time TIMESTAMP NOT NULL,
+  last_time TIMESTAMP NOT NULL,
+  state TEXT NOT NULL,
+  params TEXT NOT NULL
+)`)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-
-	if exists {
-		chat, err = m.ChatByChatID(chat.ChatID)
-		if err != nil {
-			t.Error(err)
-		}
-		chat.Abandoned = !chat.Abandoned
+	_, err = db.Exec(`INSERT INTO chat (primary_id, chat_id, type, abandoned, user_id, first_name, last_name, open_time, last_time, state, params) ` +
+		`VALUES (5, 1, 'private', false, 1, '', '', now(), now(), '{"name":"MAIN","params":{}}', '{}')`)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	err = m.Save(chat)
+	m := NewModel(db)
+	_, err = m.Init()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	chat2, err := m.ChatByChatID(88000111222)
+	chat := &dbot.Chat{ChatID: 2, Type: "private", State: dbot.StartState, Params: dbot.Params{}, Data: dbot.Data{}}
+	err = m.Insert(chat)
 	if err != nil {
-		t.Error(err)
-	}
-	if chat2.LastName != "Вава" {
-		t.Error("ChatByChatID() failed")
+		t.Fatal(err)
 	}
-	if chat2.State.Params["noo"] != "bab" {
-		t.Error("ChatByChatID() failed")
+	if chat.PrimaryID != 6 {
+		t.Errorf("inserted chat has PrimaryID %v, want 6", chat.PrimaryID)
 	}
+}
 
-	chat3, err := m.ChatByPrimaryID(chat.PrimaryID)
+func TestPostgresModelUpdateKeepsPrimaryID(t *testing.T) {
+	m := NewModel(openDB(t))
+	_, err := m.Init()
 	if err != nil {
-		t.Error(err)
-	}
-	if chat3.ChatID != -1324 {
-		t.Error("ChatByPrimaryID() failed")
-	}
-	if chat2.State.Params["noo"] != "bab" {
-		t.Error("ChatByPrimaryID() failed")
+		t.Fatal(err)
 	}
 
-	chats, err := m.ChatsByParam("noo")
+	chat := &dbot.Chat{ChatID: 1, Type: "private", State: dbot.StartState, Params: dbot.Params{}, Data: dbot.Data{}}
+	err = m.Insert(chat)
 	if err != nil {
-		t.Error(err)
-	}
-	if len(chats) != 2 {
-		t.Error("ChatsByParam() failed")
+		t.Fatal(err)
 	}
 
-	chats[1].LastTime = time.Now()
-	err = m.Save(chats[1])
+	// chat from Telegram update, PrimaryID is not known
+	updated := *chat
+	updated.PrimaryID = 0
+	updated.UserName = "foo"
+	err = m.Update(&updated)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	chatID := chats[0].ChatID
-	err = m.Delete(chats[0])
+	got, err := m.ChatByPrimaryID(chat.PrimaryID)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	_, err = m.ChatByChatID(chatID)
-	if err == nil {
-		t.Error("Deleted chat retrieved with no error!")
+	if got == nil || got.UserName != "foo" {
+		t.Errorf("got chat %v by PrimaryID %v after update, want updated chat", got, chat.PrimaryID)
 	}
-
 }
 
-func TestSqlite3ModelStateParams(t *testing.T) {
-	var m dbot.Model
-
-	db, err := sql.Open("sqlite3", "./test3.sqlite3")
-	if err != nil {
-		t.Error(err)
+// openDB skips the test if there is no database.
+func openDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("DEPECHEBOT_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("DEPECHEBOT_POSTGRES_DSN is not set")
 	}
 
-	m = NewModel(db)
-	chatIDs, err := m.Init()
-	if err != nil {
-		t.Error(err)
-	}
-	t.Logf("Number of loaded chats: %d", len(chatIDs))
-
-	params := dbot.Params(map[string]string{"foo": "bar", "noo": "bab"})
-	state := dbot.State{Name: "TEST", Params: dbot.Params{}}
-	chat := &dbot.Chat{
-		ChatID:    88000111222,
-		Abandoned: false,
-		Type:      "private",
-		UserID:    123232,
-		UserName:  "username",
-		FirstName: "first_name",
-		LastName:  "Вава",
-		OpenTime:  time.Now(),
-		LastTime:  time.Now(),
-		State:     state,
-		Params:    params,
-	}
-
-	err = m.Save(chat)
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { db.Close() })
 
-	chat, err = m.ChatByChatID(chat.ChatID)
+	_, err = db.Exec(`DROP TABLE IF EXISTS chat, tg_user, chat_member, broadcast, broadcast_recipient, schema_version`)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
+	return db
 }
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	dbot "github.com/depechebot/depechebot"
+)
+
+// where returns WHERE clause of q with its arguments.
+// JSON conditions use jsonb containment and key existence operators
+// served by GIN indexes on state and params.
+func where(q dbot.Query) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return `$` + strconv.Itoa(len(args))
+	}
+	list := func(values []string) string {
+		var ps []string
+		for _, value := range values {
+			ps = append(ps, arg(value))
+		}
+		return strings.Join(ps, `, `)
+	}
+
+	if len(q.Params) != 0 {
+		params, err := json.Marshal(q.Params)
+		if err != nil {
+			return ``, nil, err
+		}
+		conds = append(conds, `params @> `+arg(string(params))+`::jsonb`)
+	}
+	for _, key := range q.ParamKeys {
+		conds = append(conds, `params ? `+arg(key))
+	}
+	if len(q.StateParams) != 0 {
+		state, err := json.Marshal(map[string]dbot.Params{"params": q.StateParams})
+		if err != nil {
+			return ``, nil, err
+		}
+		conds = append(conds, `state @> `+arg(string(state))+`::jsonb`)
+	}
+	if len(q.StateNames) != 0 {
+		var names []string
+		for _, name := range q.StateNames {
+			names = append(names, string(name))
+		}
+		conds = append(conds, `(state ->> 'name') IN (`+list(names)+`)`)
+	}
+	if len(q.Types) != 0 {
+		conds = append(conds, `type IN (`+list(q.Types)+`)`)
+	}
+	if q.Abandoned != nil {
+		conds = append(conds, `abandoned = `+arg(*q.Abandoned))
+	}
+	if !q.LastAfter.IsZero() {
+		conds = append(conds, `last_time >= `+arg(q.LastAfter))
+	}
+	if !q.LastBefore.IsZero() {
+		conds = append(conds, `last_time < `+arg(q.LastBefore))
+	}
+
+	if len(conds) == 0 {
+		return ``, nil, nil
+	}
+	return `WHERE ` + strings.Join(conds, ` AND `) + ` `, args, nil
+}
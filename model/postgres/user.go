@@ -0,0 +1,219 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	dbot "github.com/depechebot/depechebot"
+)
+
+// SaveUser inserts or updates user by UserID and sets u.PrimaryID.
+// OpenTime of existing user is kept, nil u.Params are kept too.
+func (m Model) SaveUser(u *dbot.User) error {
+	var err error
+
+	const sqlstr = `INSERT INTO tg_user (` +
+		`user_id, user_name, first_name, last_name, open_time, last_time, params` +
+		`) VALUES (` +
+		`$1, $2, $3, $4, $5, $6, coalesce($7::jsonb, '{}')` +
+		`) ON CONFLICT (user_id) DO UPDATE SET ` +
+		`user_name = $2, first_name = $3, last_name = $4, last_time = $6, params = coalesce($7::jsonb, tg_user.params) ` +
+		`RETURNING primary_id`
+
+	var params interface{}
+	if u.Params != nil {
+		p, err := json.Marshal(u.Params)
+		if err != nil {
+			return err
+		}
+		params = string(p)
+	}
+
+	err = m.db.QueryRow(sqlstr, u.UserID, u.UserName, u.FirstName, u.LastName,
+		u.OpenTime, u.LastTime, params).Scan(&u.PrimaryID)
+	return err
+}
+
+// DeleteUser deletes the User with its chats membership from the database.
+func (m Model) DeleteUser(u *dbot.User) error {
+	var err error
+
+	const sqlstr = `DELETE FROM tg_user WHERE user_id = $1`
+
+	_, err = m.db.Exec(sqlstr, u.UserID)
+	if err != nil {
+		return err
+	}
+
+	const membersSQL = `DELETE FROM chat_member WHERE user_id = $1`
+
+	_, err = m.db.Exec(membersSQL, u.UserID)
+	return err
+}
+
+// UserByUserID retrieves a user by userID.
+func (m Model) UserByUserID(userID int) (*dbot.User, error) {
+	const sqlstr = `SELECT ` +
+		`primary_id, user_id, user_name, first_name, last_name, open_time, last_time, params ` +
+		`FROM tg_user ` +
+		`WHERE user_id = $1`
+
+	q, err := m.db.Query(sqlstr, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	users, err := scanUsers(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errors.New("user not found")
+	}
+
+	return users[0], nil
+}
+
+// AddMember adds user to chat members or updates its last activity time.
+func (m Model) AddMember(chatID dbot.ChatID, userID int, t time.Time) error {
+	var err error
+
+	const sqlstr = `INSERT INTO chat_member (` +
+		`chat_id, user_id, join_time, last_time` +
+		`) VALUES (` +
+		`$1, $2, $3, $3` +
+		`) ON CONFLICT (chat_id, user_id) DO UPDATE SET last_time = $3`
+
+	_, err = m.db.Exec(sqlstr, chatID, userID, t)
+	return err
+}
+
+// RemoveMember removes user from chat members.
+func (m Model) RemoveMember(chatID dbot.ChatID, userID int) error {
+	var err error
+
+	const sqlstr = `DELETE FROM chat_member WHERE chat_id = $1 AND user_id = $2`
+
+	_, err = m.db.Exec(sqlstr, chatID, userID)
+	return err
+}
+
+// UsersByChatID retrieves saved users which are members of chat.
+func (m Model) UsersByChatID(chatID dbot.ChatID) ([]*dbot.User, error) {
+	const sqlstr = `SELECT ` +
+		`u.primary_id, u.user_id, u.user_name, u.first_name, u.last_name, u.open_time, u.last_time, u.params ` +
+		`FROM tg_user u JOIN chat_member cm ON cm.user_id = u.user_id ` +
+		`WHERE cm.chat_id = $1 ` +
+		`ORDER BY u.primary_id`
+
+	q, err := m.db.Query(sqlstr, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	return scanUsers(q)
+}
+
+// ChatsByUserID retrieves chats the user is member of.
+func (m Model) ChatsByUserID(userID int) ([]*dbot.Chat, error) {
+	const sqlstr = `SELECT ` +
+		`c.primary_id, c.chat_id, c.type, c.abandoned, c.user_id, c.user_name, c.first_name, c.last_name, c.open_time, c.last_time, c.state, c.params, c.data ` +
+		`FROM chat c JOIN chat_member cm ON cm.chat_id = c.chat_id ` +
+		`WHERE cm.user_id = $1 ` +
+		`ORDER BY c.primary_id`
+
+	q, err := m.db.Query(sqlstr, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	return scanChats(q)
+}
+
+// MemberChat retrieves conversation of user in group chat.
+func (m Model) MemberChat(chatID dbot.ChatID, userID int) (*dbot.Chat, error) {
+	const sqlstr = `SELECT ` +
+		`c.primary_id, c.chat_id, c.type, c.abandoned, cm.user_id, ` +
+		`coalesce(u.user_name, ''), coalesce(u.first_name, ''), coalesce(u.last_name, ''), ` +
+		`cm.join_time, cm.last_time, cm.state, cm.params, cm.data ` +
+		`FROM chat_member cm JOIN chat c ON c.chat_id = cm.chat_id ` +
+		`LEFT JOIN tg_user u ON u.user_id = cm.user_id ` +
+		`WHERE cm.chat_id = $1 AND cm.user_id = $2 AND cm.state IS NOT NULL`
+
+	q, err := m.db.Query(sqlstr, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	chats, err := scanChats(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(chats) == 0 {
+		return nil, dbot.ErrNoMemberChat
+	}
+
+	return chats[0], nil
+}
+
+// SaveMemberChat saves conversation of c.UserID in group c.ChatID.
+func (m Model) SaveMemberChat(c *dbot.Chat) error {
+	var err error
+
+	const sqlstr = `INSERT INTO chat_member (` +
+		`chat_id, user_id, join_time, last_time, state, params, data` +
+		`) VALUES (` +
+		`$1, $2, $3, $3, $4, $5, $6` +
+		`) ON CONFLICT (chat_id, user_id) DO UPDATE SET ` +
+		`last_time = $3, state = $4, params = $5, data = $6`
+
+	state, err := json.Marshal(c.State)
+	if err != nil {
+		return err
+	}
+
+	params, err := json.Marshal(c.Params)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Exec(sqlstr, c.ChatID, c.UserID, c.LastTime, string(state), string(params), string(data))
+	return err
+}
+
+// scanUsers reads all users from q.
+func scanUsers(q *sql.Rows) ([]*dbot.User, error) {
+	var err error
+	var params string
+
+	users := []*dbot.User{}
+	for q.Next() {
+		u := dbot.User{}
+
+		err = q.Scan(&u.PrimaryID, &u.UserID, &u.UserName, &u.FirstName, &u.LastName,
+			&u.OpenTime, &u.LastTime, &params)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(params), &u.Params)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &u)
+	}
+
+	return users, nil
+}
@@ -1,3 +1,5 @@
+-- Manual migration from the old layout with groups column.
+-- Run it before the first Model.Init(), later fix-ups are applied by Init.
 
 drop table if exists chat2;
 CREATE TABLE chat2 (
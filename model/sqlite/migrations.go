@@ -0,0 +1,115 @@
+package sqlite
+
+import "github.com/depechebot/depechebot/model/migrate"
+
+// migrations of chat table, ordered by version.
+// Never change applied migrations, add new ones instead.
+var migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create chat table",
+		Statements: []string{`CREATE TABLE IF NOT EXISTS chat (
+  primary_id INTEGER NOT NULL PRIMARY KEY,
+  chat_id BIGINT UNIQUE NOT NULL,
+  type TEXT NOT NULL,
+  abandoned INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  user_name TEXT NOT NULL DEFAULT '',
+  first_name TEXT NOT NULL,
+  last_name TEXT NOT NULL,
+  open_time DATETIME NOT NULL,
+  last_time DATETIME NOT NULL,
+  state TEXT NOT NULL,
+  params TEXT NOT NULL
+)`},
+	},
+	{
+		// formerly migrate.sql
+		Version: 2,
+		Name:    "fix state and params JSON",
+		Statements: []string{
+			`UPDATE chat SET state = replace(state, '"params":"{}"', '"params":{}')`,
+			`UPDATE chat SET params = replace(params, char(10), ', ')`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "index chat query fields",
+		Statements: []string{
+			`CREATE INDEX IF NOT EXISTS chat_state_name ON chat (json_extract(state, '$.name'))`,
+			`CREATE INDEX IF NOT EXISTS chat_type ON chat (type, abandoned)`,
+			`CREATE INDEX IF NOT EXISTS chat_last_time ON chat (julianday(last_time))`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add chat data",
+		Statements: []string{
+			`ALTER TABLE chat ADD COLUMN data TEXT NOT NULL DEFAULT '{}'`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create user and chat member tables",
+		Statements: []string{
+			`CREATE TABLE tg_user (
+  primary_id INTEGER NOT NULL PRIMARY KEY,
+  user_id INTEGER UNIQUE NOT NULL,
+  user_name TEXT NOT NULL DEFAULT '',
+  first_name TEXT NOT NULL,
+  last_name TEXT NOT NULL,
+  open_time DATETIME NOT NULL,
+  last_time DATETIME NOT NULL,
+  params TEXT NOT NULL
+)`,
+			`CREATE TABLE chat_member (
+  chat_id BIGINT NOT NULL,
+  user_id INTEGER NOT NULL,
+  join_time DATETIME NOT NULL,
+  last_time DATETIME NOT NULL,
+  PRIMARY KEY (chat_id, user_id)
+)`,
+			`CREATE INDEX chat_member_user_id ON chat_member (user_id)`,
+		},
+	},
+	{
+		// NULL state means member has no own conversation
+		Version: 6,
+		Name:    "add chat member conversation",
+		Statements: []string{
+			`ALTER TABLE chat_member ADD COLUMN state TEXT`,
+			`ALTER TABLE chat_member ADD COLUMN params TEXT`,
+			`ALTER TABLE chat_member ADD COLUMN data TEXT`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create broadcast tables",
+		Statements: []string{
+			`CREATE TABLE broadcast (
+  id INTEGER NOT NULL PRIMARY KEY,
+  message TEXT NOT NULL,
+  status TEXT NOT NULL,
+  create_time DATETIME NOT NULL,
+  update_time DATETIME NOT NULL
+)`,
+			`CREATE INDEX broadcast_status ON broadcast (status)`,
+			`CREATE TABLE broadcast_recipient (
+  broadcast_id INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  chat_id BIGINT NOT NULL,
+  status TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  attempt_time DATETIME,
+  PRIMARY KEY (broadcast_id, chat_id)
+)`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add broadcast recipient attempts",
+		Statements: []string{
+			`ALTER TABLE broadcast_recipient ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+}
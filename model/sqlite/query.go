@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"strings"
+
+	dbot "github.com/depechebot/depechebot"
+)
+
+// where returns WHERE clause of q with its arguments.
+// Params are matched with json_each, so that keys need no escaping in JSON path.
+func where(q dbot.Query) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	for key, value := range q.Params {
+		conds = append(conds, `EXISTS (SELECT 1 FROM json_each(chat.params) WHERE key = ? AND value = ?)`)
+		args = append(args, key, value)
+	}
+	for _, key := range q.ParamKeys {
+		conds = append(conds, `EXISTS (SELECT 1 FROM json_each(chat.params) WHERE key = ?)`)
+		args = append(args, key)
+	}
+	for key, value := range q.StateParams {
+		conds = append(conds, `EXISTS (SELECT 1 FROM json_each(chat.state, '$.params') WHERE key = ? AND value = ?)`)
+		args = append(args, key, value)
+	}
+	if len(q.StateNames) != 0 {
+		conds = append(conds, `json_extract(state, '$.name') IN (`+placeholders(len(q.StateNames))+`)`)
+		for _, name := range q.StateNames {
+			args = append(args, string(name))
+		}
+	}
+	if len(q.Types) != 0 {
+		conds = append(conds, `type IN (`+placeholders(len(q.Types))+`)`)
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+	if q.Abandoned != nil {
+		conds = append(conds, `abandoned = ?`)
+		args = append(args, *q.Abandoned)
+	}
+	// times are stored as text with zone offset, so compare them as julian days
+	if !q.LastAfter.IsZero() {
+		conds = append(conds, `julianday(last_time) >= julianday(?)`)
+		args = append(args, q.LastAfter)
+	}
+	if !q.LastBefore.IsZero() {
+		conds = append(conds, `julianday(last_time) < julianday(?)`)
+		args = append(args, q.LastBefore)
+	}
+
+	if len(conds) == 0 {
+		return ``, nil
+	}
+	return `WHERE ` + strings.Join(conds, ` AND `) + ` `, args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat(`?, `, n), `, `)
+}
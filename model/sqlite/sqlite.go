@@ -4,8 +4,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	dbot "github.com/depechebot/depechebot"
+	"github.com/depechebot/depechebot/model/migrate"
 )
 
 type Model struct {
@@ -31,7 +33,7 @@ func (m Model) Init() (chatIDs []dbot.ChatID, err error) {
 	// https://github.com/mattn/go-sqlite3/issues/274
 	m.db.SetMaxOpenConns(1)
 
-	err = m.createTable()
+	err = m.Migrator().Up()
 	if err != nil {
 		return nil, err
 	}
@@ -57,29 +59,14 @@ func (m Model) Init() (chatIDs []dbot.ChatID, err error) {
 	return chatIDs, nil
 }
 
-func (m Model) createTable() error {
-	var err error
-
-	const sqlstr = `CREATE TABLE IF NOT EXISTS ` +
-		`chat` +
-		` (
-  primary_id INTEGER NOT NULL PRIMARY KEY,
-  chat_id BIGINT UNIQUE NOT NULL,
-  type TEXT NOT NULL,
-  abandoned INTEGER NOT NULL,
-  user_id INTEGER NOT NULL,
-  user_name TEXT NOT NULL DEFAULT '',
-  first_name TEXT NOT NULL,
-  last_name TEXT NOT NULL,
-  open_time DATETIME NOT NULL,
-  last_time DATETIME NOT NULL,
-  state TEXT NOT NULL,
-  params TEXT NOT NULL
-);
-`
-	_, err = m.db.Exec(sqlstr)
-
-	return err
+// Migrator returns migrator of model's schema.
+// Pending migrations are applied by Init.
+func (m Model) Migrator() migrate.Migrator {
+	return migrate.Migrator{
+		DB:         m.db,
+		Migrations: migrations,
+		Dollar:     false,
+	}
 }
 
 // Exists determines if the Chat exists in the database.
@@ -96,9 +83,9 @@ func (m Model) Insert(c *dbot.Chat) error {
 	var err error
 
 	const sqlstr = `INSERT INTO chat (` +
-		`chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params` +
+		`chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params, data` +
 		`) VALUES (` +
-		`?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?` +
+		`?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?` +
 		`)`
 
 	state, err := json.Marshal(c.State)
@@ -110,8 +97,13 @@ func (m Model) Insert(c *dbot.Chat) error {
 		return err
 	}
 
+	data, err := json.Marshal(c.Data)
+	if err != nil {
+		return err
+	}
+
 	res, err := m.db.Exec(sqlstr, c.ChatID, c.Type, c.Abandoned, c.UserID, c.UserName,
-		c.FirstName, c.LastName, c.OpenTime, c.LastTime, string(state), string(params))
+		c.FirstName, c.LastName, c.OpenTime, c.LastTime, string(state), string(params), string(data))
 	if err != nil {
 		return err
 	}
@@ -131,7 +123,7 @@ func (m Model) Update(c *dbot.Chat) error {
 	var err error
 
 	const sqlstr = `UPDATE chat SET ` +
-		`primary_id = ?, type = ?, abandoned = ?, user_id = ?, user_name = ?, first_name = ?, last_name = ?, open_time = ?, last_time = ?, state = ?, params = ?` +
+		`primary_id = ?, type = ?, abandoned = ?, user_id = ?, user_name = ?, first_name = ?, last_name = ?, open_time = ?, last_time = ?, state = ?, params = ?, data = ?` +
 		` WHERE chat_id = ?`
 
 	state, err := json.Marshal(c.State)
@@ -144,8 +136,20 @@ func (m Model) Update(c *dbot.Chat) error {
 		return err
 	}
 
-	_, err = m.db.Exec(sqlstr, c.PrimaryID, c.Type, c.Abandoned, c.UserID, c.UserName,
-		c.FirstName, c.LastName, c.OpenTime, c.LastTime, string(state), string(params), c.ChatID)
+	data, err := json.Marshal(c.Data)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.db.Exec(sqlstr, c.PrimaryID, c.Type, c.Abandoned, c.UserID, c.UserName,
+		c.FirstName, c.LastName, c.OpenTime, c.LastTime, string(state), string(params), string(data), c.ChatID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = dbot.ErrNoChat
+	}
 	return err
 }
 
@@ -170,22 +174,59 @@ func (m Model) Delete(c *dbot.Chat) error {
 	const sqlstr = `DELETE FROM chat WHERE chat_id = ?`
 
 	_, err = m.db.Exec(sqlstr, c.ChatID)
+	if err != nil {
+		return err
+	}
+
+	const membersSQL = `DELETE FROM chat_member WHERE chat_id = ?`
+
+	_, err = m.db.Exec(membersSQL, c.ChatID)
 	return err
 }
 
+// MigrateChat moves group chat to supergroup's ChatID in one transaction.
+func (m Model) MigrateChat(from, to dbot.ChatID) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var cnt int
+	err = tx.QueryRow(`SELECT count(*) FROM chat WHERE chat_id = ?`, to).Scan(&cnt)
+	if err == nil && cnt != 0 {
+		err = fmt.Errorf("chat %v already exists", to)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE chat SET chat_id = ?, type = 'supergroup' WHERE chat_id = ?`, to, from)
+	}
+	if err == nil {
+		// stale members of missing chat
+		_, err = tx.Exec(`DELETE FROM chat_member WHERE chat_id = ?`, to)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE chat_member SET chat_id = ? WHERE chat_id = ?`, to, from)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // ChatByPrimaryID retrieves a chat by primaryID.
 func (m Model) ChatByPrimaryID(primaryID int) (*dbot.Chat, error) {
 	var err error
-	var state, params string
+	var state, params, data string
 
 	const sqlstr = `SELECT ` +
-		`primary_id, chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params ` +
+		`primary_id, chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params, data ` +
 		`FROM chat ` +
 		`WHERE primary_id = ?`
 
 	c := dbot.Chat{}
 	err = m.db.QueryRow(sqlstr, primaryID).Scan(&c.PrimaryID, &c.ChatID, &c.Type, &c.Abandoned, &c.UserID, &c.UserName,
-		&c.FirstName, &c.LastName, &c.OpenTime, &c.LastTime, &state, &params)
+		&c.FirstName, &c.LastName, &c.OpenTime, &c.LastTime, &state, &params, &data)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("chat not found (use Model.Exist() before if not sure)")
@@ -204,22 +245,27 @@ func (m Model) ChatByPrimaryID(primaryID int) (*dbot.Chat, error) {
 		return nil, err
 	}
 
+	err = json.Unmarshal([]byte(data), &c.Data)
+	if err != nil {
+		return nil, err
+	}
+
 	return &c, nil
 }
 
 // ChatByChatID retrieves a chat by chatID.
 func (m Model) ChatByChatID(chatID dbot.ChatID) (*dbot.Chat, error) {
 	var err error
-	var state, params string
+	var state, params, data string
 
 	const sqlstr = `SELECT ` +
-		`primary_id, chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params ` +
+		`primary_id, chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params, data ` +
 		`FROM chat ` +
 		`WHERE chat_id = ?`
 
 	c := dbot.Chat{}
 	err = m.db.QueryRow(sqlstr, chatID).Scan(&c.PrimaryID, &c.ChatID, &c.Type, &c.Abandoned, &c.UserID, &c.UserName,
-		&c.FirstName, &c.LastName, &c.OpenTime, &c.LastTime, &state, &params)
+		&c.FirstName, &c.LastName, &c.OpenTime, &c.LastTime, &state, &params, &data)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("chat not found (use Model.Exist() before if not sure)")
@@ -238,36 +284,45 @@ func (m Model) ChatByChatID(chatID dbot.ChatID) (*dbot.Chat, error) {
 		return nil, err
 	}
 
+	err = json.Unmarshal([]byte(data), &c.Data)
+	if err != nil {
+		return nil, err
+	}
+
 	return &c, nil
 }
 
-// ChatsByParam retrieves chats with chat.Params matching param.
-func (m Model) ChatsByParam(param string) ([]*dbot.Chat, error) {
+// ChatsByQuery retrieves chats matching q.
+func (m Model) ChatsByQuery(query dbot.Query) ([]*dbot.Chat, error) {
 	var err error
-	var state, params string
 
-	const sqlstr = `SELECT ` +
-		`primary_id, chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params ` +
+	cond, args := where(query)
+	sqlstr := `SELECT ` +
+		`primary_id, chat_id, type, abandoned, user_id, user_name, first_name, last_name, open_time, last_time, state, params, data ` +
 		`FROM chat ` +
-		`WHERE ` +
-		`params like "%" || ? || "%"`
+		cond +
+		`ORDER BY primary_id`
 
-	q, err := m.db.Query(sqlstr, param)
+	q, err := m.db.Query(sqlstr, args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return []*dbot.Chat{}, nil
-		} else {
-			return nil, err
-		}
+		return nil, err
 	}
 	defer q.Close()
 
+	return scanChats(q)
+}
+
+// scanChats reads all chats from q.
+func scanChats(q *sql.Rows) ([]*dbot.Chat, error) {
+	var err error
+	var state, params, data string
+
 	chats := []*dbot.Chat{}
 	for q.Next() {
 		c := dbot.Chat{}
 
 		err = q.Scan(&c.PrimaryID, &c.ChatID, &c.Type, &c.Abandoned, &c.UserID, &c.UserName,
-			&c.FirstName, &c.LastName, &c.OpenTime, &c.LastTime, &state, &params)
+			&c.FirstName, &c.LastName, &c.OpenTime, &c.LastTime, &state, &params, &data)
 		if err != nil {
 			return nil, err
 		}
@@ -282,6 +337,11 @@ func (m Model) ChatsByParam(param string) ([]*dbot.Chat, error) {
 			return nil, err
 		}
 
+		err = json.Unmarshal([]byte(data), &c.Data)
+		if err != nil {
+			return nil, err
+		}
+
 		chats = append(chats, &c)
 	}
 
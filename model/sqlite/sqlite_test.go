@@ -2,171 +2,66 @@ package sqlite
 
 import (
 	"database/sql"
+	"path/filepath"
 	"testing"
-	"time"
 
 	dbot "github.com/depechebot/depechebot"
+	"github.com/depechebot/depechebot/model/modeltest"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func TestSqlite3ModelInit(t *testing.T) {
-	var m dbot.Model
-
-	db, err := sql.Open("sqlite3", "./test.sqlite3")
-	if err != nil {
-		t.Error(err)
-	}
-
-	m = NewModel(db)
-	chatIDs, err := m.Init()
-	if err != nil {
-		t.Error(err)
-	}
-	t.Logf("Number of loaded chats: %d", len(chatIDs))
-}
-
-func TestSqlite3ModelSaveRetrieveDelete(t *testing.T) {
-	var m dbot.Model
-
-	db, err := sql.Open("sqlite3", "./test2.sqlite3")
-	if err != nil {
-		t.Error(err)
-	}
-
-	m = NewModel(db)
-	chatIDs, err := m.Init()
-	if err != nil {
-		t.Error(err)
-	}
-	t.Logf("Number of loaded chats: %d", len(chatIDs))
-
-	params := dbot.Params(map[string]string{"foo": "bar", "noo": "bab"})
-	state := dbot.State{Name: "TEST", Params: params}
-	chat := &dbot.Chat{
-		ChatID:    88000111222,
-		Abandoned: false,
-		Type:      "private",
-		UserID:    123232,
-		UserName:  "username",
-		FirstName: "first_name",
-		LastName:  "Вава",
-		OpenTime:  time.Now(),
-		LastTime:  time.Now(),
-		State:     state,
-		Params:    params,
-	}
-
-	err = m.Save(chat)
-	if err != nil {
-		t.Error(err)
-	}
-
-	chat.ChatID = -1324
-	exists, err := m.Exists(chat)
-	if err != nil {
-		t.Error(err)
-	}
-
-	if exists {
-		chat, err = m.ChatByChatID(chat.ChatID)
+func TestSqlite3Model(t *testing.T) {
+	modeltest.Run(t, func(t *testing.T) dbot.Model {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite3"))
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
-		chat.Abandoned = !chat.Abandoned
-	}
-
-	err = m.Save(chat)
-	if err != nil {
-		t.Error(err)
-	}
+		t.Cleanup(func() { db.Close() })
 
-	chat2, err := m.ChatByChatID(88000111222)
-	if err != nil {
-		t.Error(err)
-	}
-	if chat2.LastName != "Вава" {
-		t.Error("ChatByChatID() failed")
-	}
-	if chat2.State.Params["noo"] != "bab" {
-		t.Error("ChatByChatID() failed")
-	}
+		return NewModel(db)
+	})
+}
 
-	chat3, err := m.ChatByPrimaryID(chat.PrimaryID)
+func TestSqlite3ModelMigrateExisting(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite3"))
 	if err != nil {
-		t.Error(err)
-	}
-	if chat3.ChatID != -1324 {
-		t.Error("ChatByPrimaryID() failed")
-	}
-	if chat2.State.Params["noo"] != "bab" {
-		t.Error("ChatByPrimaryID() failed")
+		t.Fatal(err)
 	}
+	defer db.Close()
 
-	chats, err := m.ChatsByParam("noo")
+	// chat table created before migrations, with data broken by old versions
+	_, err = db.Exec(migrations[0].Statements[0])
 	if err != nil {
-		t.Error(err)
-	}
-	if len(chats) != 2 {
-		t.Error("ChatsByParam() failed")
+		t.Fatal(err)
 	}
-
-	chats[1].LastTime = time.Now()
-	err = m.Save(chats[1])
+	_, err = db.Exec(`INSERT INTO chat (chat_id, type, abandoned, user_id, first_name, last_name, open_time, last_time, state, params) ` +
+		`VALUES (1, 'private', 0, 1, '', '', '2017-01-01 00:00:00', '2017-01-01 00:00:00', '{"name":"MAIN","params":"{}"}', '{"foo":"bar"}')`)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	chatID := chats[0].ChatID
-	err = m.Delete(chats[0])
+	m := NewModel(db)
+	chatIDs, err := m.Init()
 	if err != nil {
-		t.Error(err)
-	}
-	_, err = m.ChatByChatID(chatID)
-	if err == nil {
-		t.Error("Deleted chat retrieved with no error!")
+		t.Fatal(err)
 	}
-
-}
-
-func TestSqlite3ModelStateParams(t *testing.T) {
-	var m dbot.Model
-
-	db, err := sql.Open("sqlite3", "./test3.sqlite3")
-	if err != nil {
-		t.Error(err)
+	if len(chatIDs) != 1 {
+		t.Fatalf("got %v chats after migration, want 1", len(chatIDs))
 	}
 
-	m = NewModel(db)
-	chatIDs, err := m.Init()
+	chat, err := m.ChatByChatID(1)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	t.Logf("Number of loaded chats: %d", len(chatIDs))
-
-	params := dbot.Params(map[string]string{"foo": "bar", "noo": "bab"})
-	state := dbot.State{Name: "TEST", Params: dbot.Params{}}
-	chat := &dbot.Chat{
-		ChatID:    88000111222,
-		Abandoned: false,
-		Type:      "private",
-		UserID:    123232,
-		UserName:  "username",
-		FirstName: "first_name",
-		LastName:  "Вава",
-		OpenTime:  time.Now(),
-		LastTime:  time.Now(),
-		State:     state,
-		Params:    params,
+	if chat.State.Name != "MAIN" || chat.Params.Get("foo") != "bar" {
+		t.Errorf("got chat %+v after migration", chat)
 	}
 
-	err = m.Save(chat)
+	version, err := m.Migrator().Version()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-
-	chat, err = m.ChatByChatID(chat.ChatID)
-	if err != nil {
-		t.Error(err)
+	if version != migrations[len(migrations)-1].Version {
+		t.Errorf("got schema version %v, want %v", version, migrations[len(migrations)-1].Version)
 	}
-
 }
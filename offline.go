@@ -0,0 +1,68 @@
+package depechebot
+
+import (
+	"log"
+	"sync"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// RunChat runs chat's state machine over signals, as bot does for every chat,
+// but without connecting to Telegram. It returns when signals is closed.
+// Messages sent by state handlers and Chattable signals are passed to sent.
+// Chat is saved to c.Model if it's set. RunChat is intended for testing,
+// see dbottest.Conversation.
+func RunChat(c Config, chat *Chat, signals <-chan Signal, sent func(tgbotapi.Chattable)) {
+	b := Bot{Config: c}
+	b.SendChan = make(chan ChatSignal, sendChanBufSize)
+	b.SendBroadChan = make(chan BroadSignal, sendBroadChanBufSize)
+	b.api = &tgbotapi.BotAPI{}
+	b.groups = &sync.Mutex{}
+	b.broadcasts = newBroadcasts()
+	b.catalog = newCatalog(c.Catalog, c.DefaultLanguage)
+	b.offline = sent
+
+	b.runChat(chat, signals)
+}
+
+// flushOffline passes messages sent by state handlers to RunChat caller.
+func (b Bot) flushOffline() {
+	if b.offline == nil {
+		return
+	}
+
+	for {
+		select {
+		case chatSignal := <-b.SendChan:
+			signal, _ := chatSignal.unwrap()
+			msg, ok := signal.(tgbotapi.Chattable)
+			if !ok {
+				log.Printf("Dropped signal %v for chat %v\n", signal, chatSignal.ChatID)
+				continue
+			}
+			b.offline(msg)
+		default:
+			return
+		}
+	}
+}
+
+func (b Bot) saveChat(chat *Chat) error {
+	if b.Config.Model == nil {
+		return nil
+	}
+
+	err := b.migrations.save(chat, b.saveToModel)
+	if err == ErrNoChat {
+		// chat could be migrated after it's loaded, retry with new ChatID
+		err = b.migrations.save(chat, b.saveToModel)
+	}
+	return err
+}
+
+func (b Bot) saveToModel(chat *Chat) error {
+	if chat.member {
+		return b.Config.Model.SaveMemberChat(chat)
+	}
+	return b.Config.Model.Update(chat)
+}
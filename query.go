@@ -0,0 +1,140 @@
+package depechebot
+
+import "time"
+
+// Query selects chats in Model.ChatsByQuery.
+// All set conditions must match, zero Query selects all chats.
+type Query struct {
+	// Params are key/value pairs chat.Params must have, values are exact.
+	Params Params
+	// ParamKeys are keys chat.Params must have, with any value.
+	ParamKeys []string
+	// StateParams are key/value pairs chat.State.Params must have.
+	StateParams Params
+	// StateNames match chat in any of these states.
+	StateNames []StateName
+	// Types match chat of any of these types ("private", "group", ...).
+	Types []string
+	// Abandoned matches chat.Abandoned if not nil.
+	Abandoned *bool
+	// LastAfter matches chat.LastTime at or after it if not zero.
+	LastAfter time.Time
+	// LastBefore matches chat.LastTime before it if not zero.
+	LastBefore time.Time
+}
+
+func NewQuery() Query {
+	return Query{}
+}
+
+func (q Query) WithParam(key, value string) Query {
+	newQuery := q
+	newQuery.Params = q.Params.With(key, value)
+	return newQuery
+}
+
+func (q Query) WithParamKey(key string) Query {
+	newQuery := q
+	newQuery.ParamKeys = append(append([]string{}, q.ParamKeys...), key)
+	return newQuery
+}
+
+func (q Query) WithStateParam(key, value string) Query {
+	newQuery := q
+	newQuery.StateParams = q.StateParams.With(key, value)
+	return newQuery
+}
+
+func (q Query) InState(names ...StateName) Query {
+	newQuery := q
+	newQuery.StateNames = append(append([]StateName{}, q.StateNames...), names...)
+	return newQuery
+}
+
+func (q Query) OfType(types ...string) Query {
+	newQuery := q
+	newQuery.Types = append(append([]string{}, q.Types...), types...)
+	return newQuery
+}
+
+func (q Query) WithAbandoned(abandoned bool) Query {
+	newQuery := q
+	newQuery.Abandoned = &abandoned
+	return newQuery
+}
+
+// ActiveSince matches chats with last activity at or after t.
+func (q Query) ActiveSince(t time.Time) Query {
+	newQuery := q
+	newQuery.LastAfter = t
+	return newQuery
+}
+
+// InactiveSince matches chats with no activity at or after t.
+func (q Query) InactiveSince(t time.Time) Query {
+	newQuery := q
+	newQuery.LastBefore = t
+	return newQuery
+}
+
+// InactiveFor matches chats with no activity for d, e.g. to remind them.
+func (q Query) InactiveFor(d time.Duration) Query {
+	return q.InactiveSince(time.Now().Add(-d))
+}
+
+// Match reports whether chat matches the query.
+// Models without query language could use it to filter chats.
+func (q Query) Match(c *Chat) bool {
+	for key, value := range q.Params {
+		v, ok := c.Params[key]
+		if !ok || v != value {
+			return false
+		}
+	}
+	for _, key := range q.ParamKeys {
+		if _, ok := c.Params[key]; !ok {
+			return false
+		}
+	}
+	for key, value := range q.StateParams {
+		v, ok := c.State.Params[key]
+		if !ok || v != value {
+			return false
+		}
+	}
+	if len(q.StateNames) != 0 && !containsStateName(q.StateNames, c.State.Name) {
+		return false
+	}
+	if len(q.Types) != 0 && !containsString(q.Types, c.Type) {
+		return false
+	}
+	if q.Abandoned != nil && *q.Abandoned != c.Abandoned {
+		return false
+	}
+	if !q.LastAfter.IsZero() && c.LastTime.Before(q.LastAfter) {
+		return false
+	}
+	if !q.LastBefore.IsZero() && !c.LastTime.Before(q.LastBefore) {
+		return false
+	}
+
+	return true
+}
+
+func containsStateName(names []StateName, name StateName) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
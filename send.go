@@ -0,0 +1,106 @@
+package depechebot
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	sendAttempts     = 5
+	sendBackoff      = time.Second
+	sendMaxBackoff   = 30 * time.Second
+	sendMaxFloodWait = 5 * time.Minute
+)
+
+// send sends message to the chat or passes it to RunChat caller.
+// Every attempt waits for rate limits. Temporary errors are retried
+// with backoff, flood wait is honoured. Network errors are retried only
+// if message could not be delivered, otherwise it could be sent twice.
+// Retries are given up on shutdown. Returned error is *APIError.
+func (b Bot) send(msg tgbotapi.Chattable, chatID ChatID) (tgbotapi.Message, error) {
+	msg = withChatID(msg, chatID)
+	if b.offline != nil {
+		b.offline(msg)
+		return tgbotapi.Message{}, nil
+	}
+
+	backoff := sendBackoff
+	for attempt := 1; ; attempt++ {
+		b.limiter.wait(chatID)
+		message, err := b.api.Send(msg)
+		apiErr := classifyError(err)
+		if apiErr == nil {
+			return message, nil
+		}
+		if !apiErr.Temporary() || attempt == sendAttempts {
+			return message, apiErr
+		}
+		if apiErr.Kind == ErrNetwork && !apiErr.unsent() && !idempotent(msg) {
+			return message, apiErr
+		}
+
+		wait := backoff
+		if apiErr.Kind == ErrFloodWait {
+			wait = apiErr.RetryAfter
+			if wait > sendMaxFloodWait {
+				return message, apiErr
+			}
+		} else {
+			backoff *= 2
+			if backoff > sendMaxBackoff {
+				backoff = sendMaxBackoff
+			}
+		}
+		log.Printf("Failed to send (attempt %v), retrying in %v: error \"%v\"\n", attempt, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-b.life.shutdown:
+			timer.Stop()
+			return message, apiErr
+		}
+	}
+}
+
+// idempotent reports whether msg could be safely sent twice.
+func idempotent(msg tgbotapi.Chattable) bool {
+	switch msg.(type) {
+	case tgbotapi.EditMessageTextConfig, tgbotapi.EditMessageCaptionConfig,
+		tgbotapi.EditMessageReplyMarkupConfig, tgbotapi.ChatActionConfig:
+		return true
+	}
+	return false
+}
+
+// sendToChat sends message to the chat, which is marked abandoned
+// if it's not reachable anymore.
+func (b Bot) sendToChat(msg tgbotapi.Chattable, chat *Chat) {
+	_, err := b.send(msg, chat.ChatID)
+	if err != nil {
+		log.Printf("Failed to send (%v): error \"%v\"\n", marshal(msg), err)
+		if apiErr, ok := err.(*APIError); ok && apiErr.Permanent() {
+			chat.Abandoned = true
+		}
+	}
+}
+
+// withChatID sets chatID of common messages. Other Chattables are sent as is.
+func withChatID(msg tgbotapi.Chattable, chatID ChatID) tgbotapi.Chattable {
+	switch msg := msg.(type) {
+	case tgbotapi.MessageConfig:
+		msg.ChatID = int64(chatID)
+		return msg
+	case tgbotapi.PhotoConfig:
+		msg.ChatID = int64(chatID)
+		return msg
+	case tgbotapi.DocumentConfig:
+		msg.ChatID = int64(chatID)
+		return msg
+	case tgbotapi.AudioConfig:
+		msg.ChatID = int64(chatID)
+		return msg
+	}
+	return msg
+}
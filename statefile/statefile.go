@@ -0,0 +1,390 @@
+// Package statefile loads depechebot states from YAML or JSON file
+// (JSON is a subset of YAML), which is handy for simple menu bots:
+//
+//	states:
+//	  START:
+//	    next: MAIN
+//	  MAIN:
+//	    text: Main menu
+//	    keyboard:
+//	      - [Help, Settings]
+//	      - About
+//	    transitions:
+//	      Help: HELP
+//	      Settings: {state: SETTINGS, params: {section: main}}
+//	      About: {text: "Depeche Mode fan bot", state: MAIN}
+//	    default: MAIN
+//	  NAME:
+//	    text: What's your name?
+//	    keyboard: hide
+//	    callbacks: [saveName]
+//	    next: MAIN
+//	  ...
+//	group:
+//	  # StatesConfigGroup, states are used if it's missing
+//
+// Every state has optional fields:
+//   - text, parse_mode and keyboard are sent on entering state.
+//     Keyboard is a list of rows, row is a button or a list of buttons,
+//     "hide" hides keyboard.
+//   - callbacks are names of Go responsers in Callbacks, params are set
+//     to chat's Params, both on every update in the state.
+//   - transitions maps button texts (or any message text) to target,
+//     default is target of other texts, next is target of any update.
+//
+// Target is a state name or mapping with optional text to reply,
+// callbacks, params and state (the same state if it's missing).
+package statefile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	dbot "github.com/depechebot/depechebot"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+	"gopkg.in/yaml.v3"
+)
+
+// Callbacks are Go responsers referred by name from states file.
+type Callbacks map[string]dbot.Responser
+
+// States are states loaded from file.
+type States struct {
+	Private map[dbot.StateName]dbot.StateActions
+	Group   map[dbot.StateName]dbot.StateActions
+}
+
+// Apply sets states to c.
+func (s States) Apply(c *dbot.Config) {
+	c.StatesConfigPrivate = s.Private
+	c.StatesConfigGroup = s.Group
+}
+
+// Error is an error in states file at line and column.
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%v:%v:%v: %v", e.File, e.Line, e.Column, e.Msg)
+}
+
+// Errors are all errors found in states file, ordered by position.
+type Errors []Error
+
+func (e Errors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Load reads states file.
+func Load(path string, callbacks Callbacks) (States, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return States{}, err
+	}
+
+	return Parse(path, data, callbacks)
+}
+
+// Parse parses states file data, name is used in errors.
+// Returned error is Errors if data is valid YAML or JSON.
+func Parse(name string, data []byte, callbacks Callbacks) (States, error) {
+	var root yaml.Node
+	err := yaml.Unmarshal(data, &root)
+	if err != nil {
+		return States{}, fmt.Errorf("%v: %v", name, err)
+	}
+
+	p := &parser{file: name, callbacks: callbacks}
+	states := p.parseFile(&root)
+	if len(p.errs) != 0 {
+		sort.SliceStable(p.errs, func(i, j int) bool {
+			if p.errs[i].Line != p.errs[j].Line {
+				return p.errs[i].Line < p.errs[j].Line
+			}
+			return p.errs[i].Column < p.errs[j].Column
+		})
+		return States{}, p.errs
+	}
+
+	return states, nil
+}
+
+type parser struct {
+	file      string
+	callbacks Callbacks
+	errs      Errors
+}
+
+func (p *parser) errorf(node *yaml.Node, format string, args ...interface{}) {
+	p.errs = append(p.errs, Error{p.file, node.Line, node.Column, fmt.Sprintf(format, args...)})
+}
+
+// mapping returns key/value pairs of mapping node, reporting unknown keys
+// if known is not nil.
+func (p *parser) mapping(node *yaml.Node, known ...string) [][2]*yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		p.errorf(node, "expected mapping")
+		return nil
+	}
+
+	pairs := [][2]*yaml.Node{}
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if seen[key.Value] {
+			p.errorf(key, "duplicate key %q", key.Value)
+			continue
+		}
+		seen[key.Value] = true
+		if known != nil && !containsString(known, key.Value) {
+			p.errorf(key, "unknown key %q", key.Value)
+			continue
+		}
+		pairs = append(pairs, [2]*yaml.Node{key, value})
+	}
+
+	return pairs
+}
+
+func (p *parser) scalar(node *yaml.Node) string {
+	if node.Kind != yaml.ScalarNode {
+		p.errorf(node, "expected string")
+		return ""
+	}
+	return node.Value
+}
+
+func (p *parser) parseFile(root *yaml.Node) States {
+	states := States{}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		p.errorf(root, "empty states file")
+		return states
+	}
+
+	var statesNode, groupNode *yaml.Node
+	for _, pair := range p.mapping(root.Content[0], "states", "group") {
+		switch pair[0].Value {
+		case "states":
+			statesNode = pair[1]
+		case "group":
+			groupNode = pair[1]
+		}
+	}
+	if statesNode == nil {
+		p.errorf(root.Content[0], "missing states")
+		return states
+	}
+
+	states.Private = p.parseStates(statesNode)
+	states.Group = states.Private
+	if groupNode != nil {
+		states.Group = p.parseStates(groupNode)
+	}
+
+	return states
+}
+
+// target is a transition target with its position for validation.
+type target struct {
+	node  *yaml.Node
+	state dbot.StateName
+}
+
+func (p *parser) parseStates(node *yaml.Node) map[dbot.StateName]dbot.StateActions {
+	config := map[dbot.StateName]dbot.StateActions{}
+	targets := []target{}
+
+	for _, pair := range p.mapping(node) {
+		name := dbot.StateName(p.scalar(pair[0]))
+		actions, stateTargets := p.parseState(name, pair[1])
+		config[name] = actions
+		targets = append(targets, stateTargets...)
+	}
+
+	if _, ok := config[dbot.StartState.Name]; !ok {
+		p.errorf(node, "missing state %v", dbot.StartState.Name)
+	}
+	for _, t := range targets {
+		if _, ok := config[t.state]; !ok {
+			p.errorf(t.node, "transition to undefined state %v", t.state)
+		}
+	}
+
+	return config
+}
+
+func (p *parser) parseState(name dbot.StateName, node *yaml.Node) (dbot.StateActions, []target) {
+	var text dbot.Text
+	var keyboard interface{}
+	responsers := []dbot.Responser{}
+	transitions := dbot.ReqToRes{}
+	var next dbot.Responser
+	targets := []target{}
+
+	for _, pair := range p.mapping(node, "text", "parse_mode", "keyboard",
+		"callbacks", "params", "transitions", "default", "next") {
+
+		key, value := pair[0], pair[1]
+		switch key.Value {
+		case "text":
+			text.Text = p.scalar(value)
+		case "parse_mode":
+			text.ParseMode = p.parseMode(value)
+		case "keyboard":
+			keyboard = p.parseKeyboard(value)
+		case "callbacks":
+			responsers = append(responsers, p.parseCallbacks(value)...)
+		case "params":
+			responsers = append(responsers, p.parseParams(value))
+		case "transitions":
+			for _, transition := range p.mapping(value) {
+				request := dbot.NewRequest(p.scalar(transition[0]))
+				res, t := p.parseTarget(name, transition[1])
+				transitions[request] = res
+				targets = append(targets, t)
+			}
+		case "default":
+			res, t := p.parseTarget(name, value)
+			transitions[dbot.NewUnprescribedRequest()] = res
+			targets = append(targets, t)
+		case "next":
+			var t target
+			next, t = p.parseTarget(name, value)
+			targets = append(targets, t)
+		}
+	}
+
+	after := responsers
+	if len(transitions) != 0 {
+		after = append(after, transitions)
+	}
+	if next != nil {
+		after = append(after, next)
+	}
+
+	actions := dbot.StateActions{
+		While:     dbot.StateWhile(),
+		Responser: dbot.StateResponser(after...),
+	}
+	if text.Text != "" || keyboard != nil {
+		actions.Before = dbot.StateBefore(text, keyboard)
+	}
+
+	return actions, targets
+}
+
+// parseTarget returns responser of target, state is the current state.
+func (p *parser) parseTarget(state dbot.StateName, node *yaml.Node) (dbot.Responser, target) {
+	if node.Kind == yaml.ScalarNode {
+		return dbot.NewState(node.Value), target{node, dbot.StateName(node.Value)}
+	}
+
+	responsers := dbot.Responsers{}
+	t := target{node, state}
+	newState := dbot.NewState(string(state))
+	var text dbot.Text
+	for _, pair := range p.mapping(node, "text", "parse_mode", "callbacks", "params", "state") {
+		key, value := pair[0], pair[1]
+		switch key.Value {
+		case "text":
+			text.Text = p.scalar(value)
+		case "parse_mode":
+			text.ParseMode = p.parseMode(value)
+		case "callbacks":
+			responsers = append(responsers, p.parseCallbacks(value)...)
+		case "params":
+			responsers = append(responsers, p.parseParams(value))
+		case "state":
+			newState = dbot.NewState(p.scalar(value))
+			t = target{value, newState.Name}
+		}
+	}
+	if text.Text != "" {
+		responsers = append(responsers, text)
+	}
+	responsers = append(responsers, newState)
+
+	return responsers, t
+}
+
+func (p *parser) parseMode(node *yaml.Node) string {
+	mode := p.scalar(node)
+	switch mode {
+	case "", tgbotapi.ModeMarkdown, tgbotapi.ModeHTML:
+	default:
+		p.errorf(node, "unknown parse mode %q, should be %v or %v", mode, tgbotapi.ModeMarkdown, tgbotapi.ModeHTML)
+	}
+	return mode
+}
+
+func (p *parser) parseKeyboard(node *yaml.Node) interface{} {
+	if node.Kind == yaml.ScalarNode && node.Value == "hide" {
+		return dbot.NewUnprescribedRequest()
+	}
+	if node.Kind != yaml.SequenceNode {
+		p.errorf(node, "expected list of keyboard rows or \"hide\"")
+		return nil
+	}
+
+	keyboard := [][]dbot.Request{}
+	for _, rowNode := range node.Content {
+		row := []dbot.Request{}
+		if rowNode.Kind == yaml.SequenceNode {
+			for _, button := range rowNode.Content {
+				row = append(row, dbot.NewRequest(p.scalar(button)))
+			}
+		} else {
+			row = append(row, dbot.NewRequest(p.scalar(rowNode)))
+		}
+		keyboard = append(keyboard, row)
+	}
+
+	return keyboard
+}
+
+func (p *parser) parseCallbacks(node *yaml.Node) []dbot.Responser {
+	names := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		names = node.Content
+	}
+
+	responsers := []dbot.Responser{}
+	for _, nameNode := range names {
+		name := p.scalar(nameNode)
+		callback, ok := p.callbacks[name]
+		if !ok {
+			p.errorf(nameNode, "unknown callback %q", name)
+			continue
+		}
+		responsers = append(responsers, callback)
+	}
+
+	return responsers
+}
+
+func (p *parser) parseParams(node *yaml.Node) dbot.Params {
+	params := dbot.Params{}
+	for _, pair := range p.mapping(node) {
+		params.Set(p.scalar(pair[0]), p.scalar(pair[1]))
+	}
+	return params
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package statefile
+
+import (
+	"reflect"
+	"testing"
+
+	dbot "github.com/depechebot/depechebot"
+	"github.com/depechebot/depechebot/dbottest"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const testFile = `
+states:
+  START:
+    next: MAIN
+  MAIN:
+    text: Main menu
+    keyboard:
+      - [Name, About]
+      - Settings
+    transitions:
+      Name: NAME
+      About: {text: "Depeche Mode fan bot"}
+      Settings: {state: MAIN, params: {section: settings}}
+    default: MAIN
+  NAME:
+    text: What's your name?
+    keyboard: hide
+    callbacks: [saveName]
+    next: MAIN
+`
+
+var testCallbacks = Callbacks{
+	"saveName": dbot.ResponseFunc(func(bot dbot.Bot, chat dbot.Chat, update tgbotapi.Update, state *dbot.State, params *dbot.Params) {
+		params.Set("name", update.Message.Text)
+	}),
+}
+
+func TestParse(t *testing.T) {
+	states, err := Parse("states.yaml", []byte(testFile), testCallbacks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation := dbottest.Conversation{
+		States: states.Private,
+		Chat:   dbot.Chat{ChatID: 42, UserID: 42},
+	}
+	result, err := conversation.Run(dbottest.Say("/start"), dbottest.Say("About"),
+		dbottest.Say("Settings"), dbottest.Say("Name"), dbottest.Say("Dave"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Panic != nil {
+		t.Fatalf("unexpected panic: %v\n%v", result.Panic, result.Stack)
+	}
+
+	texts := []string{"Main menu", "Depeche Mode fan bot", "Main menu", "Main menu", "What's your name?", "Main menu"}
+	if !reflect.DeepEqual(result.Texts(), texts) {
+		t.Errorf("got texts %q, want %q", result.Texts(), texts)
+	}
+	if result.Chat.Params.Get("name") != "Dave" || result.Chat.Params.Get("section") != "settings" {
+		t.Errorf("got params %v", result.Chat.Params)
+	}
+	if !reflect.DeepEqual(states.Group, states.Private) {
+		t.Error("group states are not private ones")
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	_, err := Parse("states.json", []byte(`{"states": {"START": {"text": "Hi", "next": "START"}}}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const file = `states:
+  MAIN:
+    text: Main menu
+    keyboard: Name
+    transitions:
+      Name: NAEM
+      About: {state: ABOUT, txt: About}
+    callbacks: saveNmae
+    parse_mode: markdown
+    nxt: MAIN
+`
+
+	_, err := Parse("states.yaml", []byte(file), testCallbacks)
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got error %v, want Errors", err)
+	}
+
+	want := `states.yaml:2:3: missing state START
+states.yaml:4:15: expected list of keyboard rows or "hide"
+states.yaml:6:13: transition to undefined state NAEM
+states.yaml:7:22: transition to undefined state ABOUT
+states.yaml:7:29: unknown key "txt"
+states.yaml:8:16: unknown callback "saveNmae"
+states.yaml:9:17: unknown parse mode "markdown", should be Markdown or HTML
+states.yaml:10:5: unknown key "nxt"`
+	if errs.Error() != want {
+		t.Errorf("got errors:\n%v\nwant:\n%v", errs, want)
+	}
+}
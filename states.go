@@ -0,0 +1,246 @@
+package depechebot
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type transitionKind int
+
+const (
+	// transitionAny is made on any update
+	transitionAny transitionKind = iota
+	// transitionMessage is made by ReqToRes on message text
+	transitionMessage
+	// transitionCallback is made by CallbackToRes on callback data
+	transitionCallback
+)
+
+// transition is a state change found in StateActions.Responser.
+type transition struct {
+	from, to StateName
+	kind     transitionKind
+	// request is matched text, it's unprescribed for default response
+	// and for UniversalResponse if universal is true
+	request   Request
+	universal bool
+}
+
+// transitions returns state changes made by actions in state from.
+// After, ResponseFunc and custom responsers could change state in their
+// own way, dynamic is true if there are any of them.
+func transitions(from StateName, actions StateActions) (ts []transition, dynamic bool) {
+	var walk func(responser Responser, kind transitionKind, request Request)
+	walkRequests := func(responses map[Request]Responser, kind transitionKind) {
+		requests := make([]Request, 0, len(responses))
+		for request := range responses {
+			requests = append(requests, request)
+		}
+		// default response goes last
+		sort.Slice(requests, func(i, j int) bool {
+			if requests[i].unprescribed != requests[j].unprescribed {
+				return requests[j].unprescribed
+			}
+			return requests[i].Text < requests[j].Text
+		})
+
+		for _, request := range requests {
+			walk(responses[request], kind, request)
+		}
+		if _, ok := responses[NewUnprescribedRequest()]; !ok {
+			ts = append(ts, transition{from, universalState.Name, kind, NewUnprescribedRequest(), true})
+		}
+	}
+
+	walk = func(responser Responser, kind transitionKind, request Request) {
+		switch responser := responser.(type) {
+		case nil:
+		case State:
+			ts = append(ts, transition{from, responser.Name, kind, request, false})
+		case Responsers:
+			for _, r := range responser {
+				walk(r, kind, request)
+			}
+		case ReqToRes:
+			walkRequests(responser, transitionMessage)
+		case CallbackToRes:
+			walkRequests(responser, transitionCallback)
+		case Text, Photo, Document, Params, TextTemplate, PhotoTemplate:
+		default:
+			dynamic = true
+		}
+	}
+
+	if actions.After != nil {
+		return nil, true
+	}
+	walk(actions.Responser, transitionAny, Request{})
+	return ts, dynamic
+}
+
+// callbackRequests returns requests of CallbackToRes in responser, sorted.
+func callbackRequests(responser Responser) []Request {
+	requests := []Request{}
+	switch responser := responser.(type) {
+	case Responsers:
+		for _, r := range responser {
+			requests = append(requests, callbackRequests(r)...)
+		}
+	case ReqToRes:
+		for _, r := range responser {
+			requests = append(requests, callbackRequests(r)...)
+		}
+	case CallbackToRes:
+		for request, r := range responser {
+			if !request.unprescribed {
+				requests = append(requests, request)
+			}
+			requests = append(requests, callbackRequests(r)...)
+		}
+	}
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Text < requests[j].Text })
+	return requests
+}
+
+// String describes transition t for humans.
+func (t transition) String() string {
+	var on string
+	switch {
+	case t.kind == transitionAny:
+		on = "any update"
+	case t.universal:
+		on = "unmatched " + t.kind.String() + " (UniversalResponse)"
+	case t.request.unprescribed:
+		on = "default " + t.kind.String()
+	default:
+		on = fmt.Sprintf("%v %q", t.kind, t.request.Text)
+	}
+	return fmt.Sprintf("%v on %v", t.to, on)
+}
+
+func (k transitionKind) String() string {
+	if k == transitionCallback {
+		return "callback"
+	}
+	return "message"
+}
+
+// StatesError lists problems of states config found by ValidateStates.
+type StatesError struct {
+	Problems []string
+}
+
+func (e StatesError) Error() string {
+	return "invalid states config:\n\t" + strings.Join(e.Problems, "\n\t")
+}
+
+// ValidateStates checks that states has StartState, all transitions
+// of their Responsers lead to defined states, and all states are
+// reachable from StartState or signalStates. States with After,
+// ResponseFunc or custom responser could lead to any state, so states
+// are not reported as unreachable if such state is reachable itself.
+// Returned error is StatesError.
+func ValidateStates(states map[StateName]StateActions, signalStates ...StateName) error {
+	problems := []string{}
+
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	if _, ok := states[StartState.Name]; !ok {
+		problems = append(problems, fmt.Sprintf("missing state %v", StartState.Name))
+	}
+
+	next := map[StateName][]StateName{}
+	dynamic := map[StateName]bool{}
+	for _, name := range names {
+		from := StateName(name)
+		if states[from].After != nil && states[from].Responser != nil {
+			problems = append(problems, fmt.Sprintf("state %v: both After and Responser are set, Responser is not run", from))
+		}
+		for _, request := range callbackRequests(states[from].Responser) {
+			if len(request.Text) > MaxCallbackDataLen {
+				problems = append(problems, fmt.Sprintf("state %v: callback %q is longer than %v bytes, it never matches",
+					from, request.Text, MaxCallbackDataLen))
+			}
+		}
+
+		ts, dyn := transitions(from, states[from])
+		dynamic[from] = dyn
+		for _, t := range ts {
+			if _, ok := states[t.to]; !ok {
+				problems = append(problems, fmt.Sprintf("state %v: transition to undefined state %v", from, t))
+				continue
+			}
+			next[from] = append(next[from], t.to)
+		}
+	}
+
+	reached := map[StateName]bool{}
+	unknown := false
+	queue := append([]StateName{StartState.Name}, signalStates...)
+	for len(queue) != 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if reached[name] {
+			continue
+		}
+		reached[name] = true
+		unknown = unknown || dynamic[name]
+		queue = append(queue, next[name]...)
+	}
+
+	// dynamic states which are unreachable themselves lead nowhere
+	if !unknown {
+		for _, name := range names {
+			if !reached[StateName(name)] {
+				problems = append(problems, fmt.Sprintf("state %v is unreachable from %v", name, StartState.Name))
+			}
+		}
+	}
+
+	if len(problems) != 0 {
+		return StatesError{problems}
+	}
+	return nil
+}
+
+// validateStates validates StatesConfigPrivate and StatesConfigGroup
+// if they are set.
+func (c Config) validateStates() error {
+	problems := []string{}
+	configs := []struct {
+		name   string
+		states map[StateName]StateActions
+	}{
+		{"StatesConfigPrivate", c.StatesConfigPrivate},
+		{"StatesConfigGroup", c.StatesConfigGroup},
+	}
+
+	for i, config := range configs {
+		if config.states == nil {
+			continue
+		}
+		// the same map is usually used for both
+		if i > 0 && reflect.ValueOf(config.states).Pointer() == reflect.ValueOf(configs[0].states).Pointer() {
+			continue
+		}
+
+		err := ValidateStates(config.states, c.SignalStates...)
+		if err, ok := err.(StatesError); ok {
+			for _, problem := range err.Problems {
+				problems = append(problems, config.name+": "+problem)
+			}
+		}
+	}
+
+	if len(problems) != 0 {
+		return StatesError{problems}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package depechebot
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// TemplateData is passed to templates of TextTemplate and PhotoTemplate,
+// e.g. "Hello, {{.Chat.FirstName}}! You have {{.Params.count}} items".
+type TemplateData struct {
+	Chat Chat
+	// Params and StateParams are chat's params and state params,
+	// as changed by previous responsers.
+	Params      Params
+	StateParams Params
+	// Update is zero in StateBeforeResponse.
+	Update tgbotapi.Update
+}
+
+// TextTemplate is Text rendered from Go template (text/template) with
+// TemplateData. Interpolated values are escaped for its parse mode,
+// the template itself is not.
+type TextTemplate struct {
+	text      string // to be localized
+	template  *template.Template
+	parseMode string
+	Keyboard  interface{}
+}
+
+// PhotoTemplate is Photo with caption rendered from Go template
+// with TemplateData. Captions are plain text, so nothing is escaped.
+type PhotoTemplate struct {
+	text     string // to be localized
+	caption  *template.Template
+	FileID   string
+	Keyboard interface{}
+}
+
+// NewTextTemplate parses text template, it panics if text is not valid.
+func NewTextTemplate(text string) TextTemplate {
+	return newTextTemplate(text, "")
+}
+
+func NewTextTemplateWithMarkdown(text string) TextTemplate {
+	return newTextTemplate(text, tgbotapi.ModeMarkdown)
+}
+
+func NewTextTemplateWithHTML(text string) TextTemplate {
+	return newTextTemplate(text, tgbotapi.ModeHTML)
+}
+
+func newTextTemplate(text, parseMode string) TextTemplate {
+	return TextTemplate{
+		text:      text,
+		template:  mustParseTemplate(text, parseMode),
+		parseMode: parseMode,
+	}
+}
+
+// NewPhotoTemplate parses caption template, it panics if caption is not valid.
+func NewPhotoTemplate(fileID string, caption string) PhotoTemplate {
+	return PhotoTemplate{
+		text:    caption,
+		caption: mustParseTemplate(caption, ""),
+		FileID:  fileID,
+	}
+}
+
+func (text TextTemplate) WithKeyboard(keyboard interface{}) TextTemplate {
+	newText := text
+	newText.Keyboard = keyboard
+	return newText
+}
+
+func (photo PhotoTemplate) WithKeyboard(keyboard interface{}) PhotoTemplate {
+	newPhoto := photo
+	newPhoto.Keyboard = keyboard
+	return newPhoto
+}
+
+func (text TextTemplate) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
+	localize := bot.localizer(chat)
+	s, err := executeLocalizedTemplate(text.template, text.text, text.parseMode, localize,
+		chat, update, state, params)
+	if err != nil {
+		log.Printf("Failed to render template for chat %v: error \"%v\"\n", chat.ChatID, err)
+		return
+	}
+
+	sendText(bot, chat, s, text.parseMode, text.Keyboard, localize)
+}
+
+func (photo PhotoTemplate) Response(bot Bot, chat Chat, update tgbotapi.Update, state *State, params *Params) {
+	localize := bot.localizer(chat)
+	caption, err := executeLocalizedTemplate(photo.caption, photo.text, "", localize,
+		chat, update, state, params)
+	if err != nil {
+		log.Printf("Failed to render template for chat %v: error \"%v\"\n", chat.ChatID, err)
+		return
+	}
+
+	sendPhoto(bot, chat, photo.FileID, caption, photo.Keyboard, localize)
+}
+
+// executeLocalizedTemplate executes translation of text if there is one,
+// parsed t otherwise.
+func executeLocalizedTemplate(t *template.Template, text, parseMode string, localize func(string) string,
+	chat Chat, update tgbotapi.Update, state *State, params *Params) (string, error) {
+
+	if localized := localize(text); localized != text {
+		var err error
+		t, err = parseTemplate(localized, parseMode)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return executeTemplate(t, chat, update, state, params)
+}
+
+// StateBeforeResponse runs responsers, e.g. templates, on entering state.
+// Changes of State and Params made by them are ignored.
+func StateBeforeResponse(responsers ...Responser) func(bot Bot, chat Chat) {
+	return func(bot Bot, chat Chat) {
+		state := NewState(string(chat.State.Name))
+		state.Params.AddParams(chat.State.Params)
+		params := Params{}
+		params.AddParams(chat.Params)
+
+		Responsers(responsers).Response(bot, chat, tgbotapi.Update{}, &state, &params)
+	}
+}
+
+func executeTemplate(t *template.Template, chat Chat, update tgbotapi.Update,
+	state *State, params *Params) (string, error) {
+
+	var buf bytes.Buffer
+	err := t.Execute(&buf, TemplateData{
+		Chat:        chat,
+		Params:      *params,
+		StateParams: state.Params,
+		Update:      update,
+	})
+	return buf.String(), err
+}
+
+// EscapeMarkdown escapes s for Markdown parse mode.
+func EscapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+var markdownEscaper = strings.NewReplacer(`_`, `\_`, `*`, `\*`, "`", "\\`", `[`, `\[`)
+
+// EscapeHTML escapes s for HTML parse mode.
+func EscapeHTML(s string) string {
+	return html.EscapeString(s)
+}
+
+const escapeFunc = "_escape"
+
+func mustParseTemplate(text, parseMode string) *template.Template {
+	return template.Must(parseTemplate(text, parseMode))
+}
+
+// parseTemplate parses text and makes every action escape its value
+// for parseMode, as html/template does.
+func parseTemplate(text, parseMode string) (*template.Template, error) {
+	escape := func(s string) string { return s }
+	switch parseMode {
+	case tgbotapi.ModeMarkdown:
+		escape = EscapeMarkdown
+	case tgbotapi.ModeHTML:
+		escape = EscapeHTML
+	}
+
+	t, err := template.New("").Funcs(template.FuncMap{
+		escapeFunc: func(v interface{}) string {
+			return escape(fmt.Sprint(v))
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			escapeNode(tmpl.Tree, tmpl.Tree.Root)
+		}
+	}
+
+	return t, nil
+}
+
+func escapeNode(tree *parse.Tree, node parse.Node) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			escapeNode(tree, n)
+		}
+	case *parse.ActionNode:
+		// declarations like {{$x := .Value}} print nothing
+		if len(node.Pipe.Decl) != 0 {
+			return
+		}
+		node.Pipe.Cmds = append(node.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      node.Pos,
+			Args: []parse.Node{
+				parse.NewIdentifier(escapeFunc).SetTree(tree).SetPos(node.Pos),
+			},
+		})
+	case *parse.IfNode:
+		escapeNode(tree, node.List)
+		escapeNode(tree, node.ElseList)
+	case *parse.RangeNode:
+		escapeNode(tree, node.List)
+		escapeNode(tree, node.ElseList)
+	case *parse.WithNode:
+		escapeNode(tree, node.List)
+		escapeNode(tree, node.ElseList)
+	}
+}
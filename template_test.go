@@ -0,0 +1,38 @@
+package depechebot
+
+import (
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestTemplate(t *testing.T) {
+	chat := Chat{FirstName: "*Dave*_<b>", Params: Params{"count": "2"}}
+	update := tgbotapi.Update{Message: &tgbotapi.Message{Text: "[link]"}}
+	state := NewState("MAIN").WithParam("item", "a & b")
+
+	tests := []struct {
+		text TextTemplate
+		want string
+	}{
+		{NewTextTemplate("Hi, {{.Chat.FirstName}}: {{.Params.count}}"), "Hi, *Dave*_<b>: 2"},
+		{NewTextTemplateWithMarkdown("*Hi*, {{.Chat.FirstName}}! {{.Update.Message.Text}}"),
+			`*Hi*, \*Dave\*\_<b>! \[link]`},
+		{NewTextTemplateWithHTML(`<b>{{.StateParams.item}}</b>{{if .Chat.FirstName}} {{.Chat.FirstName}}{{end}}`),
+			`<b>a &amp; b</b> *Dave*_&lt;b&gt;`},
+		{NewTextTemplateWithHTML(`{{$name := .Chat.FirstName}}{{range $i, $_ := .Params}}{{$name}}{{end}}`),
+			`*Dave*_&lt;b&gt;`},
+		{NewTextTemplateWithMarkdown(`{{define "name"}}{{.FirstName}}{{end}}{{template "name" .Chat}}`),
+			`\*Dave\*\_<b>`},
+	}
+	for _, test := range tests {
+		params := chat.Params
+		s, err := executeTemplate(test.text.template, chat, update, &state, &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != test.want {
+			t.Errorf("template rendered %q, want %q", s, test.want)
+		}
+	}
+}
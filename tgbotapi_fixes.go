@@ -2,24 +2,49 @@
 package depechebot
 
 import (
+	"context"
+	"encoding/json"
 	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
 
-// GetUpdatesChan fixes tgbotapi function by adding stop signal channel
-func GetUpdatesChan(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) (<-chan tgbotapi.Update, chan<- struct{}, error) {
+// GetUpdatesChan fixes tgbotapi function by adding context.
+// Cancelling ctx aborts long polling request in flight and closes updates channel.
+func GetUpdatesChan(ctx context.Context, bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) (<-chan tgbotapi.Update, error) {
+	return getUpdatesChan(ctx, bot, config, nil)
+}
+
+// getUpdatesChan is GetUpdatesChan saving users language_code to languages.
+func getUpdatesChan(ctx context.Context, bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig,
+	languages *languageCodes) (<-chan tgbotapi.Update, error) {
+
 	updatesChan := make(chan tgbotapi.Update, 100)
-	stopChan := make(chan struct{})
+
+	// copy of bot with every request bound to ctx
+	pollBot := *bot
+	pollBot.Client = &http.Client{Transport: contextTransport{ctx, bot.Client.Transport}}
 
 	go func() {
+		defer close(updatesChan)
 		for {
-			updates, err := bot.GetUpdates(config)
+			updates, err := getUpdates(&pollBot, config, languages)
+			if ctx.Err() != nil {
+				return
+			}
 			if err != nil {
 				log.Println(err)
 				log.Println("Failed to get updates, retrying in 3 seconds...")
-				time.Sleep(time.Second * 3)
+				select {
+				case <-time.After(time.Second * 3):
+				case <-ctx.Done():
+					return
+				}
 
 				continue
 			}
@@ -27,20 +52,115 @@ func GetUpdatesChan(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) (<-chan
 			for _, update := range updates {
 				if update.UpdateID >= config.Offset {
 					config.Offset = update.UpdateID + 1
-					updatesChan <- update
+					select {
+					case updatesChan <- update:
+					case <-ctx.Done():
+						return
+					}
 				}
 			}
-
-			select {
-			case <-stopChan:
-				close(updatesChan)
-				close(stopChan)
-				return
-			default:
-				continue
-			}
 		}
 	}()
 
-	return updatesChan, stopChan, nil
+	return updatesChan, nil
+}
+
+// getUpdates fixes tgbotapi function by reading language_code
+// of updates, which tgbotapi.User doesn't have.
+func getUpdates(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig, languages *languageCodes) ([]tgbotapi.Update, error) {
+	v := url.Values{}
+	if config.Offset != 0 {
+		v.Add("offset", strconv.Itoa(config.Offset))
+	}
+	if config.Limit > 0 {
+		v.Add("limit", strconv.Itoa(config.Limit))
+	}
+	if config.Timeout > 0 {
+		v.Add("timeout", strconv.Itoa(config.Timeout))
+	}
+
+	resp, err := bot.MakeRequest("getUpdates", v)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawUpdates []json.RawMessage
+	err = json.Unmarshal(resp.Result, &rawUpdates)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]tgbotapi.Update, 0, len(rawUpdates))
+	for _, rawUpdate := range rawUpdates {
+		var update tgbotapi.Update
+		err = json.Unmarshal(rawUpdate, &update)
+		if err != nil {
+			return nil, err
+		}
+		languages.scan(rawUpdate)
+		updates = append(updates, update)
+	}
+
+	return updates, nil
+}
+
+// contextTransport binds every request to ctx.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// SetWebhook fixes tgbotapi function by adding secret token.
+// certificate is path to self-signed certificate, empty if not needed.
+func SetWebhook(bot *tgbotapi.BotAPI, link, certificate, secretToken string) error {
+	if certificate == "" {
+		v := url.Values{}
+		v.Add("url", link)
+		if secretToken != "" {
+			v.Add("secret_token", secretToken)
+		}
+
+		_, err := bot.MakeRequest("setWebhook", v)
+		return err
+	}
+
+	params := map[string]string{"url": link}
+	if secretToken != "" {
+		params["secret_token"] = secretToken
+	}
+
+	_, err := bot.UploadFile("setWebhook", params, "certificate", certificate)
+	return err
+}
+
+// endpointTransport redirects requests to another Bot API server,
+// since tgbotapi.APIEndpoint is constant.
+type endpointTransport struct {
+	endpoint *url.URL
+	base     http.RoundTripper
+}
+
+func (t endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	newReq := *req
+	newURL := *req.URL
+	newURL.Scheme = t.endpoint.Scheme
+	newURL.Host = t.endpoint.Host
+	newURL.Path = strings.TrimSuffix(t.endpoint.Path, "/") + req.URL.Path
+	newReq.URL = &newURL
+	newReq.Host = ""
+
+	return base.RoundTrip(&newReq)
 }
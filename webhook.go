@@ -0,0 +1,213 @@
+package depechebot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	webhookChanBufSize = 100
+	secretTokenHeader  = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// WebhookConfig switches bot from long polling to webhook.
+type WebhookConfig struct {
+	// URL is public https URL Telegram sends updates to.
+	URL string
+	// ListenAddr is address to serve webhook on, e.g. ":8443".
+	// If empty, bot doesn't listen itself and one should
+	// serve Bot.WebhookHandler() with his own http server.
+	ListenAddr string
+	// CertFile and KeyFile are used to serve webhook with TLS.
+	CertFile string
+	KeyFile  string
+	// SelfSigned uploads CertFile to Telegram on setWebhook.
+	SelfSigned bool
+	// SecretToken is checked against X-Telegram-Bot-Api-Secret-Token
+	// header of every request if not empty.
+	SecretToken string
+}
+
+// webhookUpdates are updates received by webhook handler, shared by all
+// copies of Bot. Updates are answered 200 once buffered, so they are
+// passed to the bot even if it's stopping.
+type webhookUpdates struct {
+	sync.RWMutex // held by handlers while buffering update
+	c            chan tgbotapi.Update
+	closed       bool
+}
+
+func newWebhookUpdates() *webhookUpdates {
+	return &webhookUpdates{c: make(chan tgbotapi.Update, webhookChanBufSize)}
+}
+
+// put buffers update, it returns false if bot is stopping.
+func (u *webhookUpdates) put(update tgbotapi.Update, stop <-chan struct{}) bool {
+	u.RLock()
+	defer u.RUnlock()
+	if u.closed {
+		return false
+	}
+
+	select {
+	case u.c <- update:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// close stops buffering updates and returns already buffered ones.
+func (u *webhookUpdates) close() []tgbotapi.Update {
+	u.Lock()
+	defer u.Unlock()
+	u.closed = true
+
+	updates := []tgbotapi.Update{}
+	for {
+		select {
+		case update := <-u.c:
+			updates = append(updates, update)
+		default:
+			return updates
+		}
+	}
+}
+
+// WebhookHandler returns http.Handler feeding Telegram updates to the bot.
+// Returns nil if bot is not configured to use webhook.
+func (b Bot) WebhookHandler() http.Handler {
+	if b.webhook.updates == nil {
+		return nil
+	}
+	return webhookHandler{
+		secretToken: b.Config.Webhook.SecretToken,
+		updates:     b.webhook.updates,
+		languages:   b.languages,
+		stop:        b.life.shutdown,
+	}
+}
+
+type webhookHandler struct {
+	secretToken string
+	updates     *webhookUpdates
+	languages   *languageCodes
+	stop        <-chan struct{}
+}
+
+func (h webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if h.secretToken != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(h.secretToken)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var update tgbotapi.Update
+	err = json.Unmarshal(body, &update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.languages.scan(body)
+
+	if !h.updates.put(update, h.stop) {
+		// Telegram will redeliver update later
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+}
+
+// startWebhook registers webhook in Telegram and starts serving it
+// if ListenAddr is set. Webhook is served until ctx is done.
+func (b *Bot) startWebhook(ctx context.Context) error {
+	c := b.Config.Webhook
+
+	certificate := ""
+	if c.SelfSigned {
+		certificate = c.CertFile
+	}
+	err := SetWebhook(b.api, c.URL, certificate, c.SecretToken)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	if c.ListenAddr != "" {
+		b.webhook.server = &http.Server{Addr: c.ListenAddr, Handler: b.WebhookHandler()}
+		go func(server *http.Server) {
+			var err error
+			if c.CertFile != "" {
+				err = server.ListenAndServeTLS(c.CertFile, c.KeyFile)
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				serveErr <- err
+			}
+		}(b.webhook.server)
+	}
+
+	updatesChan := make(chan tgbotapi.Update, webhookChanBufSize)
+	b.updatesChan = updatesChan
+	go b.forwardWebhookUpdates(ctx, updatesChan, serveErr)
+
+	return nil
+}
+
+// goroutine
+// forwardWebhookUpdates passes webhook updates to Run until ctx is done,
+// then stops webhook server and passes the rest of buffered updates.
+func (b Bot) forwardWebhookUpdates(ctx context.Context, updatesChan chan<- tgbotapi.Update, serveErr <-chan error) {
+	defer close(updatesChan)
+
+	pending := []tgbotapi.Update{}
+Loop:
+	for {
+		if len(pending) != 0 {
+			select {
+			case updatesChan <- pending[0]:
+				pending = pending[1:]
+			case <-ctx.Done():
+				break Loop
+			}
+			continue
+		}
+
+		select {
+		case update := <-b.webhook.updates.c:
+			pending = append(pending, update)
+		case err := <-serveErr:
+			log.Printf("Failed to serve webhook: error \"%v\"\n", err)
+			b.Stop()
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+
+	if b.webhook.server != nil {
+		// handlers are answering 503 as bot is stopping
+		err := b.webhook.server.Shutdown(context.Background())
+		if err != nil {
+			log.Printf("Failed to stop webhook server: error \"%v\"\n", err)
+		}
+	}
+
+	for _, update := range append(pending, b.webhook.updates.close()...) {
+		updatesChan <- update
+	}
+}
@@ -0,0 +1,120 @@
+package depechebot_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dbot "github.com/depechebot/depechebot"
+	"github.com/depechebot/depechebot/dbottest"
+	"github.com/depechebot/depechebot/model/memory"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestBotWebhook(t *testing.T) {
+	server := dbottest.NewServer()
+	defer server.Close()
+
+	model := memory.NewModel()
+	states := map[dbot.StateName]dbot.StateActions{
+		"START": {
+			While: dbot.StateWhile(),
+			After: dbot.StateAfter(dbot.NewState("MAIN")),
+		},
+		"MAIN": {
+			Before: dbot.StateBefore(dbot.NewText("Main menu"), nil),
+			While:  dbot.StateWhile(),
+			After:  dbot.StateAfter(dbot.NewState("MAIN")),
+		},
+	}
+
+	bot, err := dbot.New(dbot.Config{
+		TelegramToken:       dbottest.Token,
+		APIEndpoint:         server.URL,
+		StatesConfigPrivate: states,
+		StatesConfigGroup:   states,
+		Webhook:             &dbot.WebhookConfig{URL: "https://example.com/hook", SecretToken: "secret"},
+		Model:               model,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// before Run, which changes bot
+	handler := bot.WebhookHandler()
+
+	done := make(chan struct{})
+	go func() {
+		bot.Run(context.Background())
+		close(done)
+	}()
+	server.ExpectSent(t, "setWebhook", 0, "")
+
+	post := func(method, token, body string) int {
+		r := httptest.NewRequest(method, "/hook", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	update := func(id int, chatID int64, text string) string {
+		user := dbottest.NewUser(int(chatID), "Dave")
+		b, err := json.Marshal(tgbotapi.Update{
+			UpdateID: id,
+			Message: &tgbotapi.Message{
+				MessageID: id,
+				From:      &user,
+				Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+				Date:      int(time.Now().Unix()),
+				Text:      text,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		method, token, body string
+		code                int
+	}{
+		{http.MethodGet, "secret", update(1, 42, "/start"), http.StatusMethodNotAllowed},
+		{http.MethodPost, "", update(1, 42, "/start"), http.StatusUnauthorized},
+		{http.MethodPost, "wrong", update(1, 42, "/start"), http.StatusUnauthorized},
+		{http.MethodPost, "secret", `{"update_id": 1, "message":`, http.StatusBadRequest},
+		{http.MethodPost, "secret", update(1, 42, "/start"), http.StatusOK},
+	}
+	for _, test := range tests {
+		if code := post(test.method, test.token, test.body); code != test.code {
+			t.Errorf("%v with token %q and body %q is answered %v, want %v",
+				test.method, test.token, test.body, code, test.code)
+		}
+	}
+	server.ExpectText(t, 42, "Main menu")
+
+	// accepted update is processed even if bot is stopped right after
+	if code := post(http.MethodPost, "secret", update(2, 43, "/start")); code != http.StatusOK {
+		t.Fatalf("update is answered %v", code)
+	}
+	bot.Stop()
+	<-done
+
+	chat, err := model.ChatByChatID(43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.State.Name != "MAIN" {
+		t.Errorf("chat is saved in state %v, want MAIN", chat.State.Name)
+	}
+
+	if code := post(http.MethodPost, "secret", update(3, 42, "/start")); code != http.StatusServiceUnavailable {
+		t.Errorf("update after shutdown is answered %v, want %v", code, http.StatusServiceUnavailable)
+	}
+}